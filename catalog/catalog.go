@@ -0,0 +1,238 @@
+// Package catalog reads and writes product catalogs in CSV and JSON,
+// the formats merchandisers use to maintain products in spreadsheets.
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported format, use csv or json")
+
+// Header lists the CSV columns in export order. Imports match columns by name.
+var Header = []string{"sku", "name", "price", "manufacturer", "type", "description", "stock"}
+
+// Read parses a catalog file. Records that fail to parse or validate are
+// returned as row errors; a non-nil error means the file itself is unreadable.
+func Read(r io.Reader, format string) ([]data.ProductRecord, []data.ImportRowError, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatJSON:
+		return readJSON(r)
+	}
+	return nil, nil, ErrUnsupportedFormat
+}
+
+func readCSV(r io.Reader) ([]data.ProductRecord, []data.ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range Header {
+		if _, ok := columns[name]; !ok && name != "description" && name != "manufacturer" {
+			return nil, nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var records []data.ProductRecord
+	var rowErrors []data.ImportRowError
+	// Строка 1 — заголовок
+	for row := 2; ; row++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+
+		record := data.ProductRecord{
+			Row:          row,
+			SKU:          field("sku"),
+			Name:         field("name"),
+			Manufacturer: field("manufacturer"),
+			Type:         field("type"),
+			Description:  field("description"),
+		}
+
+		if record.Price, err = money.Parse(field("price"), money.DefaultCurrency); err != nil {
+			rowErrors = append(rowErrors, data.ImportRowError{Row: row, SKU: record.SKU, Message: "Invalid price."})
+			continue
+		}
+		if record.Stock, err = strconv.Atoi(field("stock")); err != nil {
+			rowErrors = append(rowErrors, data.ImportRowError{Row: row, SKU: record.SKU, Message: "Invalid stock."})
+			continue
+		}
+
+		if message := validate(record); message != "" {
+			rowErrors = append(rowErrors, data.ImportRowError{Row: row, SKU: record.SKU, Message: message})
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, rowErrors, nil
+}
+
+// readJSON expects an array of objects and decodes it element by element.
+func readJSON(r io.Reader) ([]data.ProductRecord, []data.ImportRowError, error) {
+	decoder := json.NewDecoder(r)
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, nil, errors.New("expected a JSON array of products")
+	}
+
+	var records []data.ProductRecord
+	var rowErrors []data.ImportRowError
+	for row := 1; decoder.More(); row++ {
+		var record data.ProductRecord
+		if err := decoder.Decode(&record); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, nil, fmt.Errorf("error decoding product %d: %w", row, err)
+			}
+			// Значение целиком прочитано декодером, поэтому можно перейти к следующему
+			message := "Invalid product."
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				message = fmt.Sprintf("Invalid %s.", typeErr.Field)
+			} else if errors.Is(err, money.ErrInvalidAmount) {
+				message = "Invalid price."
+			}
+			rowErrors = append(rowErrors, data.ImportRowError{Row: row, SKU: record.SKU, Message: message})
+			continue
+		}
+
+		record.Row = row
+		record.SKU = strings.TrimSpace(record.SKU)
+		record.Name = strings.TrimSpace(record.Name)
+		record.Manufacturer = strings.TrimSpace(record.Manufacturer)
+		record.Type = strings.TrimSpace(record.Type)
+
+		if message := validate(record); message != "" {
+			rowErrors = append(rowErrors, data.ImportRowError{Row: row, SKU: record.SKU, Message: message})
+			continue
+		}
+		records = append(records, record)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, fmt.Errorf("error reading end of array: %w", err)
+	}
+
+	return records, rowErrors, nil
+}
+
+func validate(record data.ProductRecord) string {
+	switch {
+	case record.SKU == "":
+		return "SKU is required."
+	case record.Name == "":
+		return "Product name is required."
+	case record.Type == "":
+		return "Product type is required."
+	case record.Price.IsNegative():
+		return "Price must not be negative."
+	case record.Stock < 0:
+		return "Stock must not be negative."
+	}
+	return ""
+}
+
+// Writer streams product records to an io.Writer.
+type Writer interface {
+	Write(record data.ProductRecord) error
+	// Close writes whatever the format needs after the last record and flushes.
+	Close() error
+}
+
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		writer := &csvWriter{csv: csv.NewWriter(w)}
+		return writer, writer.csv.Write(Header)
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+type csvWriter struct {
+	csv *csv.Writer
+}
+
+func (w *csvWriter) Write(record data.ProductRecord) error {
+	return w.csv.Write([]string{
+		record.SKU,
+		record.Name,
+		record.Price.String(),
+		record.Manufacturer,
+		record.Type,
+		record.Description,
+		strconv.Itoa(record.Stock),
+	})
+}
+
+func (w *csvWriter) Close() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (w *jsonWriter) Write(record data.ProductRecord) error {
+	prefix := ",\n"
+	if w.count == 0 {
+		prefix = "[\n"
+	}
+	w.count++
+
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w.w, prefix+string(raw))
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	suffix := "\n]\n"
+	if w.count == 0 {
+		suffix = "[]\n"
+	}
+	_, err := io.WriteString(w.w, suffix)
+	return err
+}
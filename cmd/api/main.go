@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
@@ -12,9 +14,17 @@ import (
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
 
 	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/db/data"
 	"github.com/Lexxxzy/go-echo-template/handlers"
+	"github.com/Lexxxzy/go-echo-template/jobs"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/Lexxxzy/go-echo-template/outbox"
+	"github.com/Lexxxzy/go-echo-template/payments"
+	"github.com/Lexxxzy/go-echo-template/pricing"
+	"github.com/Lexxxzy/go-echo-template/storage"
 )
 
 func main() {
@@ -49,6 +59,81 @@ func initializeAppEnvironment() (*echo.Echo, error) {
 		return nil, fmt.Errorf("error connecting to database: %s", err.Error())
 	}
 
+	if currency := os.Getenv("CURRENCY"); currency != "" {
+		money.DefaultCurrency = currency
+	}
+
+	if ttl := os.Getenv("CART_RESERVATION_TTL"); ttl != "" {
+		reservationTTL, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CART_RESERVATION_TTL: %s", err.Error())
+		}
+		data.ReservationTTL = reservationTTL
+	}
+	if strategy := os.Getenv("CART_MERGE_STRATEGY"); strategy != "" {
+		mergeStrategy, err := data.ParseCartMergeStrategy(strategy)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CART_MERGE_STRATEGY: %s", err.Error())
+		}
+		data.CartMergeStrategy = mergeStrategy
+	}
+	if ttl := os.Getenv("GUEST_CART_TTL"); ttl != "" {
+		guestCartTTL, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing GUEST_CART_TTL: %s", err.Error())
+		}
+		data.GuestCartTTL = guestCartTTL
+	}
+	if ttl := os.Getenv("IDEMPOTENCY_KEY_TTL"); ttl != "" {
+		idempotencyKeyTTL, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing IDEMPOTENCY_KEY_TTL: %s", err.Error())
+		}
+		data.IdempotencyKeyTTL = idempotencyKeyTTL
+	}
+	outboxInterval := 5 * time.Second
+	if interval := os.Getenv("OUTBOX_RELAY_INTERVAL"); interval != "" {
+		var err error
+		if outboxInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("error parsing OUTBOX_RELAY_INTERVAL: %s", err.Error())
+		}
+	}
+	if retention := os.Getenv("OUTBOX_RETENTION"); retention != "" {
+		outboxRetention, err := time.ParseDuration(retention)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing OUTBOX_RETENTION: %s", err.Error())
+		}
+		data.OutboxRetention = outboxRetention
+	}
+	if err := storage.Init(); err != nil {
+		return nil, fmt.Errorf("error initializing media storage: %s", err.Error())
+	}
+	if err := pricing.Init(); err != nil {
+		return nil, fmt.Errorf("error configuring shipping: %s", err.Error())
+	}
+	if err := payments.Init(); err != nil {
+		return nil, fmt.Errorf("error configuring payments: %s", err.Error())
+	}
+	if err := outbox.Init(); err != nil {
+		return nil, fmt.Errorf("error configuring outbox sinks: %s", err.Error())
+	}
+
+	jobs.Every("release-expired-reservations", time.Minute, data.ReleaseExpiredReservations)
+	jobs.Every("apply-scheduled-prices", time.Minute, data.ApplyScheduledPriceChanges)
+	jobs.Every("delete-stale-guest-carts", time.Hour, data.DeleteStaleGuestCarts)
+	jobs.Every("delete-expired-idempotency-keys", time.Hour, data.DeleteExpiredIdempotencyKeys)
+	if outbox.Default != nil {
+		jobs.Every("relay-outbox", outboxInterval, func() error {
+			return data.RelayOutbox(context.Background(), outbox.Default)
+		})
+	} else {
+		log.Warn("No outbox sinks are configured, events are not relayed")
+	}
+	jobs.Every("delete-published-outbox-events", time.Hour, data.DeletePublishedOutboxEvents)
+	jobs.Every("retry-pending-refunds", time.Minute, func() error {
+		return handlers.RetryPendingRefunds(context.Background())
+	})
+
 	gob.Register(uuid.UUID{})
 
 	e := echo.New()
@@ -57,7 +142,7 @@ func initializeAppEnvironment() (*echo.Echo, error) {
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{originPath},
 		AllowMethods: []string{"*"},
-		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", "Set-Cookie"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", "Set-Cookie", "Idempotency-Key"},
 
 		AllowCredentials: true,
 	}))
@@ -72,6 +157,10 @@ func initializeAppEnvironment() (*echo.Echo, error) {
 	e.Use(session.Middleware(store))
 	// e.Use(middleware.Secure()) // HTTPS cookies, XSS protection
 
+	if local, ok := storage.Default.(*storage.Local); ok {
+		e.Static(local.PublicURL, local.Dir)
+	}
+
 	initRoutes(e)
 
 	return e, nil
@@ -84,15 +173,85 @@ func initializeAppEnvironment() (*echo.Echo, error) {
 func initRoutes(e *echo.Echo) {
 	e.POST("/login", handlers.LoginUser)
 	e.POST("/register", handlers.Register)
-	e.GET("/products", handlers.GetProducts)
+	e.GET("/products", handlers.GetProducts, handlers.WithCurrency)
+	e.GET("/products/:id", handlers.GetProduct, handlers.WithCurrency)
+	e.GET("/products/:id/reviews", handlers.GetProductReviews)
+	e.GET("/exchange-rates", handlers.GetExchangeRates)
+	e.GET("/categories", handlers.GetCategories)
+	e.GET("/categories/:slug/products", handlers.GetCategoryProducts, handlers.WithCurrency)
+	e.POST("/payments/webhook", handlers.PaymentWebhook)
 	e.POST("/logout", handlers.LogoutUser, handlers.WithAuthentication)
 
+	guest := e.Group("/guest", handlers.WithGuestSession)
+	guest.GET("/cart", handlers.GetGuestCart, handlers.WithCurrency)
+	guest.PUT("/cart/add", handlers.AddProductToGuestCart)
+	guest.PUT("/cart/items/:id", handlers.SetGuestCartItemQuantity)
+	guest.DELETE("/cart", handlers.ClearGuestCart)
+
 	my := e.Group("/my", handlers.WithAuthentication)
-	my.GET("/cart", handlers.GetCart)
-	my.PUT("/cart/add", handlers.AddProductToCart)
-	my.DELETE("/cart/remove", handlers.RemoveProductFromCart)
+	my.GET("/cart", handlers.GetCart, handlers.WithCurrency)
+	my.PUT("/cart/add", handlers.AddProductToCart, handlers.WithIdempotency)
+	my.DELETE("/cart/remove", handlers.RemoveProductFromCart, handlers.WithIdempotency)
+	my.PUT("/cart/items/:id", handlers.SetCartItemQuantity, handlers.WithIdempotency)
+	my.DELETE("/cart", handlers.ClearCart, handlers.WithIdempotency)
+	my.POST("/cart/coupon", handlers.ApplyCoupon, handlers.WithCurrency, handlers.WithIdempotency)
+	my.DELETE("/cart/coupon", handlers.RemoveCoupon, handlers.WithIdempotency)
+	my.POST("/checkout/quote", handlers.QuoteCheckout, handlers.WithCurrency)
+
+	my.PUT("/currency", handlers.SetCurrency)
+	my.POST("/products/:id/reviews", handlers.CreateReview)
+
+	my.GET("/addresses", handlers.GetAddresses)
+	my.POST("/addresses", handlers.CreateAddress)
+	my.GET("/addresses/:id", handlers.GetAddress)
+	my.PUT("/addresses/:id", handlers.UpdateAddress)
+	my.DELETE("/addresses/:id", handlers.DeleteAddress)
 
 	my.GET("/orders", handlers.GetOrders)
-	my.POST("/orders/add", handlers.PlaceOrder)
+	my.GET("/orders/:id", handlers.GetOrder)
+	my.POST("/orders/add", handlers.PlaceOrder, handlers.WithCurrency, handlers.WithIdempotency)
 	my.DELETE("/orders/cancel", handlers.CancelOrder)
+	my.POST("/orders/:id/returns", handlers.CreateReturn, handlers.WithIdempotency)
+	my.GET("/returns", handlers.GetReturns)
+	my.GET("/returns/:id", handlers.GetReturn)
+
+	admin := e.Group("/admin", handlers.WithAuthentication, handlers.WithRole(data.RoleAdmin))
+	admin.POST("/products", handlers.CreateProduct)
+	admin.POST("/products/import", handlers.ImportProducts)
+	admin.GET("/products/export", handlers.ExportProducts)
+	admin.PUT("/products/:id", handlers.UpdateProduct)
+	admin.DELETE("/products/:id", handlers.DeleteProduct)
+	admin.POST("/products/:id/restore", handlers.RestoreProduct)
+	admin.POST("/products/:id/images", handlers.UploadProductImages)
+	admin.DELETE("/products/:id/images/:imageId", handlers.DeleteProductImage)
+	admin.GET("/products/:id/prices", handlers.GetProductPrices)
+	admin.POST("/products/:id/price-changes", handlers.SchedulePriceChange)
+	admin.DELETE("/price-changes/:id", handlers.CancelPriceChange)
+
+	admin.POST("/reviews/:id/hide", handlers.HideReview)
+	admin.POST("/reviews/:id/unhide", handlers.UnhideReview)
+	admin.DELETE("/reviews/:id", handlers.DeleteReview)
+
+	admin.PUT("/exchange-rates/:currency", handlers.SetExchangeRate)
+	admin.DELETE("/exchange-rates/:currency", handlers.DeleteExchangeRate)
+
+	admin.PUT("/orders/:id/status", handlers.SetOrderStatus)
+	admin.GET("/orders/:id/events", handlers.GetOrderEvents)
+
+	admin.GET("/returns", handlers.GetAllReturns)
+	admin.GET("/returns/:id/events", handlers.GetReturnEvents)
+	admin.PUT("/returns/:id/status", handlers.SetReturnStatus)
+
+	admin.GET("/tax-rates", handlers.GetTaxRates)
+	admin.PUT("/tax-rates/:region", handlers.SetTaxRate)
+	admin.DELETE("/tax-rates/:region", handlers.DeleteTaxRate)
+
+	admin.GET("/coupons", handlers.GetCoupons)
+	admin.POST("/coupons", handlers.CreateCoupon)
+	admin.DELETE("/coupons/:id", handlers.DisableCoupon)
+
+	admin.POST("/product-types", handlers.CreateProductType)
+	admin.PUT("/product-types/:id", handlers.UpdateProductType)
+	admin.DELETE("/product-types/:id", handlers.DeleteProductType)
+	admin.POST("/product-types/:id/restore", handlers.RestoreProductType)
 }
@@ -0,0 +1,141 @@
+// Command catalog imports and exports the product catalog.
+//
+// Usage:
+//
+//	catalog import [-dev] [-format csv|json] [-dry-run] <file>
+//	catalog export [-dev] [-format csv|json] [-o file]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+
+	"github.com/Lexxxzy/go-echo-template/catalog"
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/db/data"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: catalog import [-dev] [-format csv|json] [-dry-run] <file>")
+	fmt.Fprintln(os.Stderr, "       catalog export [-dev] [-format csv|json] [-o file]")
+	os.Exit(2)
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	isDevelopment := flags.Bool("dev", false, "Use dev.env file as environment")
+	format := flags.String("format", "", "File format, csv or json (defaults to the file extension)")
+	dryRun := flags.Bool("dry-run", false, "Validate and report without saving")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, rowErrors, err := catalog.Read(file, *format)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err.Error())
+	}
+
+	if err := connect(*isDevelopment); err != nil {
+		return err
+	}
+
+	report, err := data.ImportProducts(context.Background(), records, rowErrors, *dryRun)
+	if err != nil {
+		return fmt.Errorf("error importing products: %s", err.Error())
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	isDevelopment := flags.Bool("dev", false, "Use dev.env file as environment")
+	format := flags.String("format", catalog.FormatCSV, "File format, csv or json")
+	output := flags.String("o", "", "Output file (defaults to stdout)")
+	flags.Parse(args)
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	writer, err := catalog.NewWriter(out, *format)
+	if err != nil {
+		return err
+	}
+
+	if err := connect(*isDevelopment); err != nil {
+		return err
+	}
+
+	if err := data.ExportProducts(context.Background(), writer.Write); err != nil {
+		return fmt.Errorf("error exporting products: %s", err.Error())
+	}
+
+	return writer.Close()
+}
+
+// connect loads the environment the same way the API does and connects to the database.
+func connect(isDevelopment bool) error {
+	envFile := ".env"
+	if isDevelopment {
+		envFile = "dev.env"
+	}
+	if err := godotenv.Load(envFile); err != nil {
+		return fmt.Errorf("error reading %s: %s", envFile, err.Error())
+	}
+
+	if err := db.Init(os.Getenv("PGPOOL_INSTANCES_PATH")); err != nil {
+		return fmt.Errorf("error connecting to database: %s", err.Error())
+	}
+
+	return nil
+}
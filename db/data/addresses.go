@@ -0,0 +1,250 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+var ErrAddressNotFound = errors.New("address not found")
+
+type Address struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Line1     string    `json:"line1"`
+	Line2     string    `json:"line2"`
+	City      string    `json:"city"`
+	Postcode  string    `json:"postcode"`
+	Country   string    `json:"country"`
+	Phone     string    `json:"phone"`
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// String formats the address on one line, as stored in the delivery address of an order.
+func (address Address) String() string {
+	parts := []string{address.Name, address.Line1}
+	if address.Line2 != "" {
+		parts = append(parts, address.Line2)
+	}
+	parts = append(parts, strings.TrimSpace(address.Postcode+" "+address.City), address.Country)
+	if address.Phone != "" {
+		parts = append(parts, address.Phone)
+	}
+	return strings.Join(parts, ", ")
+}
+
+const addressColumns = `id, name, line1, line2, city, postcode, country, phone, is_default, created_at, updated_at`
+
+// GetAddresses lists the user's addresses, the default one first.
+func GetAddresses(userID string) ([]Address, error) {
+	addresses := []Address{}
+
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = ? ORDER BY is_default DESC, id`
+	rows, err := db.Proxy.GetCurrentDB().Query(query, userID)
+	if err != nil {
+		log.Error("Error fetching addresses: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+func GetAddress(userID string, addressID int) (Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE id = ? AND user_id = ?`
+	return scanAddress(db.Proxy.GetCurrentDB().QueryRow(query, addressID, userID))
+}
+
+// CreateAddress saves a new address of the user. The first address of a user
+// becomes the default one.
+func CreateAddress(userID string, address *Address) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if !address.IsDefault {
+		var count int
+		if err = tx.QueryRow(`SELECT count(*) FROM addresses WHERE user_id = ?`, userID).Scan(&count); err != nil {
+			log.Error("Error counting addresses: ", err)
+			return err
+		}
+		address.IsDefault = count == 0
+	}
+	if address.IsDefault {
+		if err = clearDefaultAddress(tx, userID); err != nil {
+			return err
+		}
+	}
+
+	query := `
+	INSERT INTO addresses (user_id, name, line1, line2, city, postcode, country, phone, is_default)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(query, userID, address.Name, address.Line1, address.Line2, address.City, address.Postcode,
+		address.Country, address.Phone, address.IsDefault).Scan(&address.ID, &address.CreatedAt, &address.UpdatedAt)
+	if err != nil {
+		log.Error("Error creating address: ", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateAddress replaces the fields of an address of the user. Unsetting the
+// default flag of the default address is ignored; another address has to be
+// made the default instead.
+func UpdateAddress(userID string, address *Address) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var isDefault bool
+	err = tx.QueryRow(`SELECT is_default FROM addresses WHERE id = ? AND user_id = ? FOR UPDATE`, address.ID, userID).Scan(&isDefault)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAddressNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching address: ", err)
+		return err
+	}
+
+	if address.IsDefault && !isDefault {
+		if err = clearDefaultAddress(tx, userID); err != nil {
+			return err
+		}
+	}
+	address.IsDefault = address.IsDefault || isDefault
+
+	query := `
+	UPDATE addresses
+	SET name = ?, line1 = ?, line2 = ?, city = ?, postcode = ?, country = ?, phone = ?, is_default = ?, updated_at = NOW()
+	WHERE id = ?
+	RETURNING created_at, updated_at
+	`
+	err = tx.QueryRow(query, address.Name, address.Line1, address.Line2, address.City, address.Postcode, address.Country,
+		address.Phone, address.IsDefault, address.ID).Scan(&address.CreatedAt, &address.UpdatedAt)
+	if err != nil {
+		log.Error("Error updating address: ", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAddress deletes an address of the user. Orders placed with it keep their copy.
+// If it was the default address, the most recently added remaining address takes its place.
+func DeleteAddress(userID string, addressID int) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var isDefault bool
+	err = tx.QueryRow(`DELETE FROM addresses WHERE id = ? AND user_id = ? RETURNING is_default`, addressID, userID).Scan(&isDefault)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAddressNotFound
+	}
+	if err != nil {
+		log.Error("Error deleting address: ", err)
+		return err
+	}
+
+	if isDefault {
+		query := `
+		UPDATE addresses SET is_default = true, updated_at = NOW()
+		WHERE id = (SELECT max(id) FROM addresses WHERE user_id = ?)
+		`
+		if _, err = tx.Exec(query, userID); err != nil {
+			log.Error("Error updating default address: ", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// orderAddress returns the address of the user to deliver an order to: the
+// chosen one, or the default one if none was chosen. It returns nil if the
+// user chose no address and has no default address.
+func orderAddress(tx bun.Tx, userID string, addressID int) (*Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = ? AND is_default`
+	args := []interface{}{userID}
+	if addressID != 0 {
+		query = `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = ? AND id = ?`
+		args = append(args, addressID)
+	}
+
+	address, err := scanAddress(tx.QueryRow(query, args...))
+	if errors.Is(err, ErrAddressNotFound) && addressID == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+func clearDefaultAddress(tx bun.Tx, userID string) error {
+	if _, err := tx.Exec(`UPDATE addresses SET is_default = false, updated_at = NOW() WHERE user_id = ? AND is_default`, userID); err != nil {
+		log.Error("Error updating default address: ", err)
+		return err
+	}
+	return nil
+}
+
+func scanAddress(row interface{ Scan(...interface{}) error }) (Address, error) {
+	var address Address
+	err := row.Scan(&address.ID, &address.Name, &address.Line1, &address.Line2, &address.City, &address.Postcode,
+		&address.Country, &address.Phone, &address.IsDefault, &address.CreatedAt, &address.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return address, ErrAddressNotFound
+	}
+	if err != nil {
+		log.Error("Error scanning address: ", err)
+	}
+	return address, err
+}
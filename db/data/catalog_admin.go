@@ -0,0 +1,214 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+)
+
+var (
+	ErrSlugTaken = errors.New("slug is already taken")
+	ErrSKUTaken  = errors.New("sku is already taken")
+
+	ErrProductTypeCycle = errors.New("product type cannot be a descendant of itself")
+)
+
+// ProductInput holds the editable fields of a product.
+type ProductInput struct {
+	SKU           string      `json:"sku"`
+	Name          string      `json:"name"`
+	Price         money.Money `json:"price"`
+	Manufacturer  string      `json:"manufacturer"`
+	ProductTypeID int         `json:"product_type_id"`
+	Description   string      `json:"description"`
+	Stock         int         `json:"stock"`
+	WeightGrams   int         `json:"weight_grams"`
+}
+
+// ProductTypeInput holds the editable fields of a product type.
+type ProductTypeInput struct {
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID *int   `json:"parent_id"`
+}
+
+func CreateProduct(input ProductInput) (int, error) {
+	var id int
+	query := `
+	INSERT INTO products (sku, name, price, manufacturer, product_type_id, description, stock, weight_grams)
+	VALUES (NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id
+	`
+	err := db.Proxy.GetPrimaryDB().QueryRow(query,
+		input.SKU, input.Name, input.Price, input.Manufacturer, input.ProductTypeID, input.Description, input.Stock, input.WeightGrams,
+	).Scan(&id)
+	if hasPgErrorCode(err, pgForeignKeyViolation) {
+		return 0, ErrCategoryNotFound
+	}
+	if hasPgErrorCode(err, pgUniqueViolation) {
+		return 0, ErrSKUTaken
+	}
+	if err != nil {
+		log.Error("Error creating product: ", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func UpdateProduct(id int, input ProductInput) error {
+	query := `
+	UPDATE products
+	SET sku = COALESCE(NULLIF(?, ''), sku), name = ?, price = ?, manufacturer = ?, product_type_id = ?, description = ?, stock = ?, weight_grams = ?
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query,
+		input.SKU, input.Name, input.Price, input.Manufacturer, input.ProductTypeID, input.Description, input.Stock, input.WeightGrams, id,
+	)
+	if hasPgErrorCode(err, pgForeignKeyViolation) {
+		return ErrCategoryNotFound
+	}
+	if hasPgErrorCode(err, pgUniqueViolation) {
+		return ErrSKUTaken
+	}
+	if err != nil {
+		log.Error("Error updating product: ", err)
+		return err
+	}
+
+	return expectAffected(result, ErrProductNotFound)
+}
+
+func DeleteProduct(id int) error {
+	query := `UPDATE products SET deleted_at = NOW() WHERE id = ? AND deleted_at IS NULL`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query, id)
+	if err != nil {
+		log.Error("Error deleting product: ", err)
+		return err
+	}
+
+	return expectAffected(result, ErrProductNotFound)
+}
+
+func RestoreProduct(id int) error {
+	query := `UPDATE products SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query, id)
+	if err != nil {
+		log.Error("Error restoring product: ", err)
+		return err
+	}
+
+	return expectAffected(result, ErrProductNotFound)
+}
+
+func CreateProductType(input ProductTypeInput) (int, error) {
+	var id int
+	query := `INSERT INTO product_types (name, slug, parent_id) VALUES (?, ?, ?) RETURNING id`
+	err := db.Proxy.GetPrimaryDB().QueryRow(query, input.Name, input.Slug, input.ParentID).Scan(&id)
+	if err != nil {
+		log.Error("Error creating product type: ", err)
+		return 0, productTypeError(err)
+	}
+
+	return id, nil
+}
+
+// UpdateProductType saves the fields of a product type. It returns ErrProductTypeCycle
+// if the new parent is the product type itself or one of its descendants.
+func UpdateProductType(id int, input ProductTypeInput) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if input.ParentID != nil {
+		// Блокировка дерева типов, чтобы параллельные изменения не создали цикл
+		if _, err = tx.Exec(`LOCK TABLE product_types IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			log.Error("Error locking product types: ", err)
+			return err
+		}
+
+		var cycle bool
+		cycleQuery := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM product_types WHERE id = ?
+			UNION
+			SELECT pt.id, pt.parent_id FROM product_types pt JOIN ancestors a ON pt.id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = ?)
+		`
+		if err = tx.QueryRow(cycleQuery, *input.ParentID, id).Scan(&cycle); err != nil {
+			log.Error("Error checking product type ancestors: ", err)
+			return err
+		}
+		if cycle {
+			return ErrProductTypeCycle
+		}
+	}
+
+	query := `UPDATE product_types SET name = ?, slug = ?, parent_id = ? WHERE id = ? AND deleted_at IS NULL`
+	result, err := tx.Exec(query, input.Name, input.Slug, input.ParentID, id)
+	if err != nil {
+		log.Error("Error updating product type: ", err)
+		return productTypeError(err)
+	}
+	if err = expectAffected(result, ErrCategoryNotFound); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func DeleteProductType(id int) error {
+	query := `UPDATE product_types SET deleted_at = NOW() WHERE id = ? AND deleted_at IS NULL`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query, id)
+	if err != nil {
+		log.Error("Error deleting product type: ", err)
+		return err
+	}
+
+	return expectAffected(result, ErrCategoryNotFound)
+}
+
+func RestoreProductType(id int) error {
+	query := `UPDATE product_types SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query, id)
+	if err != nil {
+		log.Error("Error restoring product type: ", err)
+		return err
+	}
+
+	return expectAffected(result, ErrCategoryNotFound)
+}
+
+func productTypeError(err error) error {
+	switch {
+	case hasPgErrorCode(err, pgUniqueViolation):
+		return ErrSlugTaken
+	case hasPgErrorCode(err, pgForeignKeyViolation):
+		return ErrCategoryNotFound
+	}
+	return err
+}
+
+// expectAffected returns notFound if the statement did not change any row.
+func expectAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
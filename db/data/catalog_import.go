@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+)
+
+// ProductRecord is a product as it appears in catalog imports and exports.
+// Type refers to the product type by its slug. Row is the position of the
+// record in the imported file and is only used for error reports.
+type ProductRecord struct {
+	Row          int         `json:"-"`
+	SKU          string      `json:"sku"`
+	Name         string      `json:"name"`
+	Price        money.Money `json:"price"`
+	Manufacturer string      `json:"manufacturer"`
+	Type         string      `json:"type"`
+	Description  string      `json:"description"`
+	Stock        int         `json:"stock"`
+}
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	SKU     string `json:"sku,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	DryRun  bool             `json:"dry_run"`
+	Total   int              `json:"total"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Failed  int              `json:"failed"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// ImportProducts upserts the records by SKU in a single transaction on the primary.
+// Every row runs in its own savepoint, so a failing row is reported and skipped
+// without affecting the others. SKUs of deleted products are reported, not restored. In dry-run mode the transaction is rolled back.
+// Rows that failed to parse are passed in rowErrors and counted as failed.
+func ImportProducts(ctx context.Context, records []ProductRecord, rowErrors []ImportRowError, dryRun bool) (ImportReport, error) {
+	report := ImportReport{
+		DryRun: dryRun,
+		Total:  len(records) + len(rowErrors),
+		Failed: len(rowErrors),
+		Errors: append([]ImportRowError{}, rowErrors...),
+	}
+
+	tx, err := db.Proxy.GetPrimaryDB().BeginTx(ctx, nil)
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return report, err
+	}
+	defer tx.Rollback()
+
+	upsertQuery := `
+	INSERT INTO products (sku, name, price, manufacturer, product_type_id, description, stock)
+	SELECT ?, ?, CAST(? AS numeric), ?, pt.id, ?, ?
+	FROM product_types pt
+	WHERE pt.slug = ? AND pt.deleted_at IS NULL
+	ON CONFLICT (sku) DO UPDATE SET
+		name = EXCLUDED.name,
+		price = EXCLUDED.price,
+		manufacturer = EXCLUDED.manufacturer,
+		product_type_id = EXCLUDED.product_type_id,
+		description = EXCLUDED.description,
+		stock = EXCLUDED.stock
+	WHERE products.deleted_at IS NULL
+	RETURNING (xmax = 0) AS created
+	`
+
+	for _, record := range records {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+			log.Error("Error creating savepoint: ", err)
+			return report, err
+		}
+
+		var created bool
+		err := tx.QueryRowContext(ctx, upsertQuery,
+			record.SKU, record.Name, record.Price, record.Manufacturer, record.Description, record.Stock, record.Type,
+		).Scan(&created)
+
+		if err != nil {
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); rollbackErr != nil {
+				log.Error("Error rolling back to savepoint: ", rollbackErr)
+				return report, rollbackErr
+			}
+			if _, releaseErr := tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row"); releaseErr != nil {
+				log.Error("Error releasing savepoint: ", releaseErr)
+				return report, releaseErr
+			}
+
+			message := "Failed to save product."
+			if errors.Is(err, sql.ErrNoRows) {
+				// Строка не вставлена: неизвестный тип или SKU удалённого товара
+				var deleted bool
+				deletedQuery := `SELECT EXISTS (SELECT 1 FROM products WHERE sku = ? AND deleted_at IS NOT NULL)`
+				if err := tx.QueryRowContext(ctx, deletedQuery, record.SKU).Scan(&deleted); err != nil {
+					log.Error("Error checking deleted product: ", err)
+					return report, err
+				}
+				message = fmt.Sprintf("Unknown product type %q.", record.Type)
+				if deleted {
+					message = "Product with this SKU was deleted."
+				}
+			} else {
+				log.Error("Error importing product: ", err)
+			}
+			report.Failed++
+			report.Errors = append(report.Errors, ImportRowError{Row: record.Row, SKU: record.SKU, Message: message})
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row"); err != nil {
+			log.Error("Error releasing savepoint: ", err)
+			return report, err
+		}
+
+		if created {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return report, err
+	}
+
+	return report, nil
+}
+
+// ExportProducts calls write for every product of the catalog, reading rows one
+// at a time so the catalog is never held in memory as a whole.
+func ExportProducts(ctx context.Context, write func(ProductRecord) error) error {
+	query := `
+	SELECT coalesce(p.sku, ''), p.name, p.price, coalesce(p.manufacturer, ''), pt.slug, p.description, p.stock
+	FROM products p
+	JOIN product_types pt ON p.product_type_id = pt.id
+	WHERE p.deleted_at IS NULL
+	ORDER BY p.id
+	`
+	rows, err := db.Proxy.GetReplicaDB().QueryContext(ctx, query)
+	if err != nil {
+		log.Error("Error exporting products: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record ProductRecord
+		err := rows.Scan(&record.SKU, &record.Name, &record.Price, &record.Manufacturer, &record.Type, &record.Description, &record.Stock)
+		if err != nil {
+			log.Error("Error scanning product: ", err)
+			return err
+		}
+		record.Name = strings.TrimSpace(record.Name)
+		record.Manufacturer = strings.TrimSpace(record.Manufacturer)
+
+		if err := write(record); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/labstack/gommon/log"
+)
+
+var ErrCategoryNotFound = errors.New("category not found")
+
+type Category struct {
+	ID       int         `bun:"type:int,pk" json:"id"`
+	Name     string      `bun:"type:char(64),notnull" json:"name"`
+	Slug     string      `bun:"type:text,unique,notnull" json:"slug"`
+	ParentID *int        `bun:"type:int" json:"parent_id"`
+	Children []*Category `json:"children,omitempty"`
+}
+
+// categorySubtree matches product types in the category selected by the
+// condition and all of its subcategories.
+func categorySubtree(condition string) string {
+	return `p.product_type_id IN (
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM product_types WHERE ` + condition + `
+			UNION
+			SELECT child.id FROM product_types child JOIN subtree s ON child.parent_id = s.id
+		)
+		SELECT id FROM subtree
+	)`
+}
+
+// GetCategories returns the category tree, top-level categories first.
+func GetCategories() ([]*Category, error) {
+	query := `SELECT id, name, slug, parent_id FROM product_types WHERE deleted_at IS NULL ORDER BY name`
+
+	rows, err := db.Proxy.GetCurrentDB().Query(query)
+	if err != nil {
+		log.Error("Error fetching categories: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []*Category
+	byID := make(map[int]*Category)
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.Slug, &category.ParentID); err != nil {
+			log.Error("Error scanning category: ", err)
+			return nil, err
+		}
+		category.Name = strings.TrimSpace(category.Name)
+		categories = append(categories, &category)
+		byID[category.ID] = &category
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	var roots []*Category
+	for _, category := range categories {
+		if category.ParentID != nil {
+			if parent, ok := byID[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, category)
+				continue
+			}
+		}
+		roots = append(roots, category)
+	}
+
+	return roots, nil
+}
+
+func GetCategoryBySlug(slug string) (Category, error) {
+	var category Category
+	query := `SELECT id, name, slug, parent_id FROM product_types WHERE slug = ? AND deleted_at IS NULL`
+
+	err := db.Proxy.GetCurrentDB().QueryRow(query, slug).Scan(&category.ID, &category.Name, &category.Slug, &category.ParentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return category, ErrCategoryNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching category: ", err)
+		return category, err
+	}
+	category.Name = strings.TrimSpace(category.Name)
+
+	return category, nil
+}
@@ -0,0 +1,174 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/Lexxxzy/go-echo-template/pricing"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+var (
+	ErrCartEmpty       = errors.New("cart is empty")
+	ErrTaxRateNotFound = errors.New("tax rate not found")
+	ErrRegionMismatch  = errors.New("region is not in the country of the delivery address")
+)
+
+type TaxRate struct {
+	Region    string    `json:"region"`
+	Rate      string    `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func GetTaxRates() ([]TaxRate, error) {
+	rates := []TaxRate{}
+
+	rows, err := db.Proxy.GetReplicaDB().Query(`SELECT region, rate, updated_at FROM tax_rates ORDER BY region`)
+	if err != nil {
+		log.Error("Error fetching tax rates: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate TaxRate
+		if err := rows.Scan(&rate.Region, &rate.Rate, &rate.UpdatedAt); err != nil {
+			log.Error("Error scanning tax rate: ", err)
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// SetTaxRate creates or replaces the tax rate of a region, in percent.
+func SetTaxRate(region string, rate string) error {
+	query := `
+	INSERT INTO tax_rates (region, rate) VALUES (upper(?), CAST(? AS numeric))
+	ON CONFLICT (region) DO UPDATE SET rate = EXCLUDED.rate, updated_at = NOW()
+	`
+	if _, err := db.Proxy.GetPrimaryDB().Exec(query, region, rate); err != nil {
+		log.Error("Error saving tax rate: ", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteTaxRate(region string) error {
+	result, err := db.Proxy.GetPrimaryDB().Exec(`DELETE FROM tax_rates WHERE region = upper(?)`, region)
+	if err != nil {
+		log.Error("Error deleting tax rate: ", err)
+		return err
+	}
+	return expectAffected(result, ErrTaxRateNotFound)
+}
+
+// QuoteCart previews the price breakdown of the user's cart delivered to the region.
+func QuoteCart(userID string, region string) (pricing.Quote, error) {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return pricing.Quote{}, err
+	}
+	defer tx.Rollback()
+
+	discount := money.New(0, money.DefaultCurrency)
+	cartDiscount, err := cartDiscount(tx, userID)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+	if cartDiscount != nil {
+		discount = cartDiscount.Total
+	}
+
+	return quoteCart(tx, userID, region, discount)
+}
+
+// quoteCart runs the user's cart through the pricing pipeline.
+func quoteCart(tx bun.Tx, userID string, region string, discount money.Money) (pricing.Quote, error) {
+	quote := pricing.Quote{Region: strings.ToUpper(strings.TrimSpace(region)), Discount: discount}
+
+	query := `
+	SELECT p.id, p.price, ci.quantity, p.weight_grams
+	FROM cart_items ci
+	JOIN cart c ON ci.cart_id = c.id
+	JOIN products p ON ci.product_id = p.id
+	WHERE c.user_id = ?
+	ORDER BY p.id
+	`
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		log.Error("Error fetching cart for quote: ", err)
+		return quote, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line pricing.Line
+		if err := rows.Scan(&line.ProductID, &line.Price, &line.Quantity, &line.WeightGrams); err != nil {
+			log.Error("Error scanning cart item: ", err)
+			return quote, err
+		}
+		quote.Lines = append(quote.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return quote, err
+	}
+	if len(quote.Lines) == 0 {
+		return quote, ErrCartEmpty
+	}
+
+	if quote.TaxRate, err = taxRateFor(tx, quote.Region); err != nil {
+		return quote, err
+	}
+
+	if err := pricing.Default.Run(&quote); err != nil {
+		log.Error("Error pricing cart: ", err)
+		return quote, err
+	}
+
+	return quote, nil
+}
+
+// taxRateFor returns the tax rate of the region, falling back to the rate of its
+// country for regions like 'US-CA'. Regions without a rate are not taxed.
+func taxRateFor(tx bun.Tx, region string) (*big.Rat, error) {
+	if region == "" {
+		return nil, nil
+	}
+
+	var rate string
+	query := `
+	SELECT rate FROM tax_rates
+	WHERE region IN (?, split_part(?, '-', 1))
+	ORDER BY length(region) DESC
+	LIMIT 1
+	`
+	err := tx.QueryRow(query, region, region).Scan(&rate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error("Error fetching tax rate: ", err)
+		return nil, err
+	}
+
+	percent, ok := new(big.Rat).SetString(rate)
+	if !ok {
+		return nil, errors.New("invalid tax rate " + rate)
+	}
+	return percent, nil
+}
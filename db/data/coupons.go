@@ -0,0 +1,470 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// Kinds of coupon discounts.
+const (
+	CouponPercent = "percent"
+	CouponFixed   = "fixed"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponCodeTaken     = errors.New("coupon code is already taken")
+	ErrCouponScopeNotFound = errors.New("coupon product or category not found")
+	ErrCouponInactive      = errors.New("coupon is not active")
+	ErrCouponUsedUp        = errors.New("coupon usage limit reached")
+	ErrCouponMinOrder      = errors.New("order total is below the coupon minimum")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to any item in the cart")
+)
+
+// Coupon is a discount code. Value is a percentage for percent coupons and an
+// amount in the base currency for fixed ones. A fixed discount is spread over
+// the matching cart lines in proportion to their totals.
+type Coupon struct {
+	ID             int          `json:"id"`
+	Code           string       `json:"code"`
+	Kind           string       `json:"kind"`
+	Value          json.Number  `json:"value"`
+	MinOrder       *money.Money `json:"min_order"`
+	ProductID      *int         `json:"product_id"`
+	CategoryID     *int         `json:"category_id"`
+	MaxUses        *int         `json:"max_uses"`
+	MaxUsesPerUser *int         `json:"max_uses_per_user"`
+	Uses           int          `json:"uses"`
+	StartsAt       *time.Time   `json:"starts_at"`
+	EndsAt         *time.Time   `json:"ends_at"`
+	DisabledAt     *time.Time   `json:"disabled_at,omitempty"`
+}
+
+// CartDiscount is the discount a coupon gives on each line of the cart.
+// Error explains why a coupon applied earlier no longer gives a discount.
+type CartDiscount struct {
+	Code  string         `json:"code"`
+	Lines []LineDiscount `json:"lines"`
+	Total money.Money    `json:"total"`
+	Error string         `json:"error,omitempty"`
+}
+
+type LineDiscount struct {
+	ProductID int         `json:"product_id"`
+	Discount  money.Money `json:"discount"`
+}
+
+// couponLine is a cart line as seen by the discount calculation.
+type couponLine struct {
+	productID int
+	total     money.Money
+	eligible  bool
+}
+
+const couponColumns = `id, code, kind, value, min_order, product_id, category_id,
+	max_uses, max_uses_per_user, uses, starts_at, ends_at, disabled_at`
+
+func scanCoupon(row interface{ Scan(...interface{}) error }) (Coupon, error) {
+	var coupon Coupon
+	err := row.Scan(&coupon.ID, &coupon.Code, &coupon.Kind, &coupon.Value, &coupon.MinOrder, &coupon.ProductID, &coupon.CategoryID,
+		&coupon.MaxUses, &coupon.MaxUsesPerUser, &coupon.Uses, &coupon.StartsAt, &coupon.EndsAt, &coupon.DisabledAt)
+	return coupon, err
+}
+
+func GetCoupons() ([]Coupon, error) {
+	coupons := []Coupon{}
+
+	rows, err := db.Proxy.GetReplicaDB().Query(`SELECT ` + couponColumns + ` FROM coupons ORDER BY id DESC`)
+	if err != nil {
+		log.Error("Error fetching coupons: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			log.Error("Error scanning coupon: ", err)
+			return nil, err
+		}
+		coupons = append(coupons, coupon)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return coupons, nil
+}
+
+func CreateCoupon(coupon *Coupon) error {
+	query := `
+	INSERT INTO coupons (code, kind, value, min_order, product_id, category_id, max_uses, max_uses_per_user, starts_at, ends_at)
+	VALUES (?, ?, CAST(? AS numeric), ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id
+	`
+	err := db.Proxy.GetPrimaryDB().QueryRow(query, coupon.Code, coupon.Kind, coupon.Value, coupon.MinOrder, coupon.ProductID, coupon.CategoryID,
+		coupon.MaxUses, coupon.MaxUsesPerUser, coupon.StartsAt, coupon.EndsAt).Scan(&coupon.ID)
+	switch {
+	case hasPgErrorCode(err, pgUniqueViolation):
+		return ErrCouponCodeTaken
+	case hasPgErrorCode(err, pgForeignKeyViolation):
+		return ErrCouponScopeNotFound
+	case err != nil:
+		log.Error("Error creating coupon: ", err)
+		return err
+	}
+	coupon.Uses = 0
+
+	return nil
+}
+
+// DisableCoupon stops a coupon from being applied. Orders that already used it keep their discount.
+func DisableCoupon(id int) error {
+	result, err := db.Proxy.GetPrimaryDB().Exec(`UPDATE coupons SET disabled_at = NOW() WHERE id = ? AND disabled_at IS NULL`, id)
+	if err != nil {
+		log.Error("Error disabling coupon: ", err)
+		return err
+	}
+	return expectAffected(result, ErrCouponNotFound)
+}
+
+// ApplyCartCoupon attaches a coupon to the user's cart and returns the discount it gives.
+func ApplyCartCoupon(userID string, code string) (CartDiscount, error) {
+	var discount CartDiscount
+
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return discount, err
+	}
+	defer tx.Rollback()
+
+	coupon, err := scanCoupon(tx.QueryRow(`SELECT `+couponColumns+` FROM coupons WHERE upper(code) = upper(?)`, strings.TrimSpace(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return discount, ErrCouponNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching coupon: ", err)
+		return discount, err
+	}
+
+	if discount, err = cartCouponDiscount(tx, coupon, userID); err != nil {
+		return discount, err
+	}
+
+	cartID, err := getOrCreateCart(tx, userID)
+	if err != nil {
+		return discount, err
+	}
+	if _, err = tx.Exec(`UPDATE cart SET coupon_id = ? WHERE id = ?`, coupon.ID, cartID); err != nil {
+		log.Error("Error applying coupon: ", err)
+		return discount, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return discount, err
+	}
+
+	return discount, nil
+}
+
+func RemoveCartCoupon(userID string) error {
+	_, err := db.Proxy.GetPrimaryDB().Exec(`UPDATE cart SET coupon_id = NULL WHERE user_id = ?`, userID)
+	if err != nil {
+		log.Error("Error removing coupon: ", err)
+		return err
+	}
+	return nil
+}
+
+// GetCartDiscount returns the discount of the coupon applied to the user's cart,
+// or nil if there is none.
+func GetCartDiscount(userID string) (*CartDiscount, error) {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return cartDiscount(tx, userID)
+}
+
+func cartDiscount(tx bun.Tx, userID string) (*CartDiscount, error) {
+	coupon, err := scanCoupon(tx.QueryRow(`
+	SELECT `+couponColumns+` FROM coupons
+	WHERE id = (SELECT coupon_id FROM cart WHERE user_id = ?)
+	`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error("Error fetching cart coupon: ", err)
+		return nil, err
+	}
+
+	discount, err := cartCouponDiscount(tx, coupon, userID)
+	if isCouponError(err) {
+		// Купон остаётся в корзине, но скидка не применяется, пока условия не выполнены
+		return &CartDiscount{Code: coupon.Code, Lines: []LineDiscount{}, Total: money.New(0, money.DefaultCurrency), Error: err.Error()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &discount, nil
+}
+
+// redeemCartCoupon applies the coupon of the user's cart to the order, counts the redemption
+// and returns the discount. It must run before the cart is cleared. The coupon row is locked,
+// so concurrent orders cannot exceed its usage limits.
+func redeemCartCoupon(tx bun.Tx, userID string, orderID int) (money.Money, error) {
+	noDiscount := money.New(0, money.DefaultCurrency)
+
+	coupon, err := scanCoupon(tx.QueryRow(`
+	SELECT `+couponColumns+` FROM coupons
+	WHERE id = (SELECT coupon_id FROM cart WHERE user_id = ?)
+	FOR UPDATE
+	`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return noDiscount, nil
+	}
+	if err != nil {
+		log.Error("Error fetching cart coupon: ", err)
+		return noDiscount, err
+	}
+
+	discount, err := cartCouponDiscount(tx, coupon, userID)
+	if err != nil {
+		return noDiscount, err
+	}
+
+	for _, line := range discount.Lines {
+		_, err = tx.Exec(`UPDATE order_items SET discount = ? WHERE order_id = ? AND product_id = ?`, line.Discount, orderID, line.ProductID)
+		if err != nil {
+			log.Error("Error saving order item discount: ", err)
+			return noDiscount, err
+		}
+	}
+
+	if _, err = tx.Exec(`UPDATE orders SET coupon_id = ?, discount = ? WHERE id = ?`, coupon.ID, discount.Total, orderID); err != nil {
+		log.Error("Error saving order discount: ", err)
+		return noDiscount, err
+	}
+
+	redemptionQuery := `INSERT INTO coupon_redemptions (coupon_id, user_id, order_id, discount) VALUES (?, ?, ?, ?)`
+	if _, err = tx.Exec(redemptionQuery, coupon.ID, userID, orderID, discount.Total); err != nil {
+		log.Error("Error saving coupon redemption: ", err)
+		return noDiscount, err
+	}
+
+	if _, err = tx.Exec(`UPDATE coupons SET uses = uses + 1 WHERE id = ?`, coupon.ID); err != nil {
+		log.Error("Error counting coupon use: ", err)
+		return noDiscount, err
+	}
+
+	if _, err = tx.Exec(`UPDATE cart SET coupon_id = NULL WHERE user_id = ?`, userID); err != nil {
+		log.Error("Error removing coupon from cart: ", err)
+		return noDiscount, err
+	}
+
+	return discount.Total, nil
+}
+
+// releaseCouponRedemption gives back the coupon use of a cancelled order.
+func releaseCouponRedemption(tx bun.Tx, orderID int) error {
+	query := `
+	WITH released AS (
+		DELETE FROM coupon_redemptions WHERE order_id = ? RETURNING coupon_id
+	)
+	UPDATE coupons c SET uses = c.uses - 1 FROM released r WHERE c.id = r.coupon_id
+	`
+	if _, err := tx.Exec(query, orderID); err != nil {
+		log.Error("Error releasing coupon redemption: ", err)
+		return err
+	}
+	return nil
+}
+
+// cartCouponDiscount checks that the coupon can be used by the user and
+// calculates its discount on the user's cart.
+func cartCouponDiscount(tx bun.Tx, coupon Coupon, userID string) (CartDiscount, error) {
+	var discount CartDiscount
+
+	now := time.Now()
+	switch {
+	case coupon.DisabledAt != nil,
+		coupon.StartsAt != nil && now.Before(*coupon.StartsAt),
+		coupon.EndsAt != nil && !now.Before(*coupon.EndsAt):
+		return discount, ErrCouponInactive
+	case coupon.MaxUses != nil && coupon.Uses >= *coupon.MaxUses:
+		return discount, ErrCouponUsedUp
+	}
+
+	if coupon.MaxUsesPerUser != nil {
+		var used int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND user_id = ?`, coupon.ID, userID).Scan(&used)
+		if err != nil {
+			log.Error("Error counting coupon redemptions: ", err)
+			return discount, err
+		}
+		if used >= *coupon.MaxUsesPerUser {
+			return discount, ErrCouponUsedUp
+		}
+	}
+
+	lines, err := couponCartLines(tx, coupon, userID)
+	if err != nil {
+		return discount, err
+	}
+
+	discount.Code = coupon.Code
+	discount.Lines, err = coupon.discount(lines)
+	if err != nil {
+		return discount, err
+	}
+	discount.Total = money.New(0, money.DefaultCurrency)
+	for _, line := range discount.Lines {
+		if discount.Total, err = discount.Total.Add(line.Discount); err != nil {
+			log.Error("Error calculating coupon discount: ", err)
+			return discount, err
+		}
+	}
+
+	return discount, nil
+}
+
+// couponCartLines loads the user's cart lines and marks those within the coupon's scope.
+func couponCartLines(tx bun.Tx, coupon Coupon, userID string) ([]couponLine, error) {
+	eligible := []string{"true"}
+	var args []interface{}
+	if coupon.ProductID != nil {
+		eligible = append(eligible, "p.id = ?")
+		args = append(args, *coupon.ProductID)
+	}
+	if coupon.CategoryID != nil {
+		eligible = append(eligible, categorySubtree("id = ?"))
+		args = append(args, *coupon.CategoryID)
+	}
+
+	query := `
+	SELECT p.id, p.price, ci.quantity, ` + strings.Join(eligible, " AND ") + `
+	FROM cart_items ci
+	JOIN cart c ON ci.cart_id = c.id
+	JOIN products p ON ci.product_id = p.id
+	WHERE c.user_id = ?
+	ORDER BY p.id
+	`
+	rows, err := tx.Query(query, append(args, userID)...)
+	if err != nil {
+		log.Error("Error fetching cart for coupon: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []couponLine
+	for rows.Next() {
+		var line couponLine
+		var price money.Money
+		var quantity int
+		if err := rows.Scan(&line.productID, &price, &quantity, &line.eligible); err != nil {
+			log.Error("Error scanning cart item: ", err)
+			return nil, err
+		}
+		line.total = price.Mul(quantity)
+		lines = append(lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// discount splits the coupon discount over the eligible lines.
+func (coupon Coupon) discount(lines []couponLine) ([]LineDiscount, error) {
+	subtotal := money.New(0, money.DefaultCurrency)
+	eligibleTotal := money.New(0, money.DefaultCurrency)
+	for _, line := range lines {
+		var err error
+		if subtotal, err = subtotal.Add(line.total); err != nil {
+			return nil, err
+		}
+		if !line.eligible {
+			continue
+		}
+		if eligibleTotal, err = eligibleTotal.Add(line.total); err != nil {
+			return nil, err
+		}
+	}
+
+	if coupon.MinOrder != nil && subtotal.Amount < coupon.MinOrder.Amount {
+		return nil, ErrCouponMinOrder
+	}
+	if eligibleTotal.Amount <= 0 {
+		return nil, ErrCouponNotApplicable
+	}
+
+	value, err := money.Parse(coupon.Value.String(), money.DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []LineDiscount{}
+	switch coupon.Kind {
+	case CouponPercent:
+		// Процент хранится с двумя знаками, поэтому value.Amount — это сотые доли процента
+		for _, line := range lines {
+			if !line.eligible {
+				continue
+			}
+			amount := (line.total.Amount*value.Amount + 5000) / 10000
+			result = append(result, LineDiscount{ProductID: line.productID, Discount: money.New(amount, line.total.Currency)})
+		}
+	case CouponFixed:
+		// Скидка не может превышать стоимость подходящих товаров
+		total := value.Amount
+		if total > eligibleTotal.Amount {
+			total = eligibleTotal.Amount
+		}
+		// Распределяем пропорционально, остаток от округления достаётся последней строке
+		left := total
+		last := -1
+		for i, line := range lines {
+			if line.eligible {
+				last = i
+			}
+		}
+		for i, line := range lines {
+			if !line.eligible {
+				continue
+			}
+			amount := total * line.total.Amount / eligibleTotal.Amount
+			if i == last {
+				amount = left
+			}
+			left -= amount
+			result = append(result, LineDiscount{ProductID: line.productID, Discount: money.New(amount, line.total.Currency)})
+		}
+	}
+
+	return result, nil
+}
+
+func isCouponError(err error) bool {
+	return errors.Is(err, ErrCouponInactive) || errors.Is(err, ErrCouponUsedUp) ||
+		errors.Is(err, ErrCouponMinOrder) || errors.Is(err, ErrCouponNotApplicable)
+}
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	usd := func(minor int64) money.Money { return money.New(minor, money.DefaultCurrency) }
+	minOrder := usd(5000)
+
+	lines := []couponLine{
+		{productID: 1, total: usd(1000), eligible: true},
+		{productID: 2, total: usd(2000), eligible: false},
+		{productID: 3, total: usd(333), eligible: true},
+		{productID: 4, total: usd(667), eligible: true},
+	}
+
+	tests := []struct {
+		name   string
+		coupon Coupon
+		want   map[int]int64
+		err    error
+	}{
+		{
+			name:   "percent of every eligible line, rounded half up",
+			coupon: Coupon{Kind: CouponPercent, Value: "15"},
+			// 150, 49.95 and 100.05 cents
+			want: map[int]int64{1: 150, 3: 50, 4: 100},
+		},
+		{
+			name:   "fixed amount split in proportion, remainder on the last line",
+			coupon: Coupon{Kind: CouponFixed, Value: "10.00"},
+			// 1000 * 1000/2000, 1000 * 333/2000 = 166.5
+			want: map[int]int64{1: 500, 3: 166, 4: 334},
+		},
+		{
+			name:   "fixed amount capped at the eligible total",
+			coupon: Coupon{Kind: CouponFixed, Value: "100.00"},
+			want:   map[int]int64{1: 1000, 3: 333, 4: 667},
+		},
+		{
+			name:   "minimum order not reached",
+			coupon: Coupon{Kind: CouponFixed, Value: "5.00", MinOrder: &minOrder},
+			err:    ErrCouponMinOrder,
+		},
+	}
+
+	for _, test := range tests {
+		discounts, err := test.coupon.discount(lines)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: discount returned %v, want %v", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: discount returned %v", test.name, err)
+			continue
+		}
+
+		if len(discounts) != len(test.want) {
+			t.Errorf("%s: got %d line discounts, want %d", test.name, len(discounts), len(test.want))
+			continue
+		}
+		for _, discount := range discounts {
+			if want := test.want[discount.ProductID]; discount.Discount.Amount != want {
+				t.Errorf("%s: discount of product %d = %d, want %d", test.name, discount.ProductID, discount.Discount.Amount, want)
+			}
+		}
+	}
+}
+
+func TestCouponDiscountWithoutEligibleLines(t *testing.T) {
+	lines := []couponLine{{productID: 1, total: money.New(1000, money.DefaultCurrency)}}
+	coupon := Coupon{Kind: CouponPercent, Value: "10"}
+	if _, err := coupon.discount(lines); !errors.Is(err, ErrCouponNotApplicable) {
+		t.Errorf("discount without eligible lines returned %v, want ErrCouponNotApplicable", err)
+	}
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+)
+
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+type ExchangeRate struct {
+	Currency  string    `bun:"type:char(3),pk" json:"currency"`
+	Rate      string    `bun:"type:numeric(18,8),notnull" json:"rate"`
+	UpdatedAt time.Time `bun:"type:timestamptz,notnull" json:"updated_at"`
+}
+
+// GetRate returns the rate from the base currency into the currency.
+// The base currency itself always has a rate of 1.
+func GetRate(currency string) (money.Rate, error) {
+	currency = strings.ToUpper(currency)
+	if currency == "" || currency == money.DefaultCurrency {
+		return money.BaseRate(), nil
+	}
+
+	var value string
+	err := db.Proxy.GetCurrentDB().QueryRow(`SELECT rate FROM exchange_rates WHERE currency = ?`, currency).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return money.Rate{}, ErrUnknownCurrency
+	}
+	if err != nil {
+		log.Error("Error fetching exchange rate: ", err)
+		return money.Rate{}, err
+	}
+
+	return money.ParseRate(value, currency)
+}
+
+func GetExchangeRates() ([]ExchangeRate, error) {
+	rates := []ExchangeRate{}
+	rows, err := db.Proxy.GetCurrentDB().Query(`SELECT currency, rate, updated_at FROM exchange_rates ORDER BY currency`)
+	if err != nil {
+		log.Error("Error fetching exchange rates: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			log.Error("Error scanning exchange rate: ", err)
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+func SetExchangeRate(rate money.Rate) error {
+	query := `
+	INSERT INTO exchange_rates (currency, rate, updated_at)
+	VALUES (?, CAST(? AS numeric), NOW())
+	ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = EXCLUDED.updated_at
+	`
+	if _, err := db.Proxy.GetPrimaryDB().Exec(query, rate.Currency, rate.String()); err != nil {
+		log.Error("Error saving exchange rate: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func DeleteExchangeRate(currency string) error {
+	result, err := db.Proxy.GetPrimaryDB().Exec(`DELETE FROM exchange_rates WHERE currency = ?`, strings.ToUpper(currency))
+	if err != nil {
+		log.Error("Error deleting exchange rate: ", err)
+		return err
+	}
+
+	return expectAffected(result, ErrUnknownCurrency)
+}
+
+// SetUserCurrency saves the currency the user wants to see prices in.
+func SetUserCurrency(userID string, currency string) error {
+	_, err := db.Proxy.GetPrimaryDB().Exec(`UPDATE users SET currency = NULLIF(?, '') WHERE id = ?`, strings.ToUpper(currency), userID)
+	if err != nil {
+		log.Error("Error saving user currency: ", err)
+		return err
+	}
+
+	return nil
+}
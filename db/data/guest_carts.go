@@ -0,0 +1,244 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// Rules for merging a guest cart into the user's cart when both hold the same product.
+const (
+	CartMergeSum   = "sum"   // add the quantities together
+	CartMergeMax   = "max"   // keep the larger quantity
+	CartMergeGuest = "guest" // take the quantity from the guest cart
+	CartMergeUser  = "user"  // keep the quantity already in the user's cart
+)
+
+var cartMergeQuantities = map[string]string{
+	CartMergeSum:   "cart_items.quantity + EXCLUDED.quantity",
+	CartMergeMax:   "GREATEST(cart_items.quantity, EXCLUDED.quantity)",
+	CartMergeGuest: "EXCLUDED.quantity",
+	CartMergeUser:  "cart_items.quantity",
+}
+
+var (
+	// CartMergeStrategy is the rule used by MergeGuestCart.
+	CartMergeStrategy = CartMergeSum
+
+	// GuestCartTTL is how long a guest cart is kept after its last change.
+	GuestCartTTL = 30 * 24 * time.Hour
+)
+
+// ParseCartMergeStrategy validates the name of a cart merge rule.
+func ParseCartMergeStrategy(s string) (string, error) {
+	if _, ok := cartMergeQuantities[s]; !ok {
+		return "", fmt.Errorf("unknown cart merge strategy %q", s)
+	}
+	return s, nil
+}
+
+func GetGuestCartItems(guestID string) ([]CartItem, error) {
+	query := `
+        SELECT p.id, p.name, p.price, ci.quantity
+        FROM cart_items ci
+        JOIN products p ON ci.product_id = p.id
+        JOIN cart c ON ci.cart_id = c.id
+        WHERE c.guest_id = ?
+    `
+
+	rows, err := db.Proxy.GetCurrentDB().Query(query, guestID)
+	if err != nil {
+		log.Error("Error fetching guest cart items: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return MapRowsToCartItems(rows)
+}
+
+// AddProductToGuestCart increments the quantity of a product in the guest cart.
+func AddProductToGuestCart(guestID string, productID int, quantity int) error {
+	query := `
+        INSERT INTO cart_items (cart_id, product_id, quantity)
+        VALUES (?, ?, ?)
+        ON CONFLICT (cart_id, product_id)
+        DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity
+	`
+	return updateGuestCart(guestID, query, productID, quantity)
+}
+
+// SetGuestCartItemQuantity sets the exact quantity of a product in the guest cart.
+// A zero quantity removes the product.
+func SetGuestCartItemQuantity(guestID string, productID int, quantity int) error {
+	if quantity == 0 {
+		return updateGuestCart(guestID, `DELETE FROM cart_items WHERE cart_id = ? AND product_id = ?`, productID)
+	}
+
+	query := `
+        INSERT INTO cart_items (cart_id, product_id, quantity)
+        VALUES (?, ?, ?)
+        ON CONFLICT (cart_id, product_id)
+        DO UPDATE SET quantity = EXCLUDED.quantity
+	`
+	return updateGuestCart(guestID, query, productID, quantity)
+}
+
+func ClearGuestCart(guestID string) error {
+	query := `DELETE FROM cart_items WHERE cart_id IN (SELECT id FROM cart WHERE guest_id = ?)`
+	_, err := db.Proxy.GetPrimaryDB().Exec(query, guestID)
+	if err != nil {
+		log.Error("Error clearing guest cart: ", err)
+		return err
+	}
+	return nil
+}
+
+// updateGuestCart runs a query against the guest cart, creating the cart if needed.
+// The query receives the cart id followed by args.
+func updateGuestCart(guestID string, query string, args ...interface{}) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var cartID int
+	cartQuery := `
+        INSERT INTO cart (guest_id) VALUES (?)
+        ON CONFLICT (guest_id) DO UPDATE SET updated_at = NOW()
+        RETURNING id
+	`
+	if err = tx.QueryRow(cartQuery, guestID).Scan(&cartID); err != nil {
+		log.Error("Error fetching guest cart: ", err)
+		return err
+	}
+
+	_, err = tx.Exec(query, append([]interface{}{cartID}, args...)...)
+	if hasPgErrorCode(err, pgForeignKeyViolation) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		log.Error("Error updating guest cart: ", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// MergeGuestCart moves the items of the guest cart into the user's cart and deletes the guest cart.
+// Products present in both carts are merged according to CartMergeStrategy.
+func MergeGuestCart(guestID string, userID string) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Шаг 1: Находим гостевую корзину и блокируем её от параллельных изменений
+	var guestCartID int
+	err = tx.QueryRow(`SELECT id FROM cart WHERE guest_id = ? FOR UPDATE`, guestID).Scan(&guestCartID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Error("Error fetching guest cart: ", err)
+		return err
+	}
+
+	cartID, err := getOrCreateCart(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Шаг 2: Переносим товары, совпадающие позиции объединяем по выбранному правилу
+	if err = mergeCartItems(tx, guestCartID, cartID); err != nil {
+		return err
+	}
+
+	// Шаг 3: Удаляем гостевую корзину
+	if _, err = tx.Exec(`DELETE FROM cart_items WHERE cart_id = ?`, guestCartID); err != nil {
+		log.Error("Error clearing guest cart: ", err)
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM cart WHERE id = ?`, guestCartID); err != nil {
+		log.Error("Error deleting guest cart: ", err)
+		return err
+	}
+
+	// Резерв не может превышать количество товара в корзине
+	if err = syncReservations(tx, userID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func mergeCartItems(tx bun.Tx, fromCartID int, toCartID int) error {
+	quantity, ok := cartMergeQuantities[CartMergeStrategy]
+	if !ok {
+		quantity = cartMergeQuantities[CartMergeSum]
+	}
+
+	query := `
+        INSERT INTO cart_items (cart_id, product_id, quantity)
+        SELECT ?, product_id, quantity FROM cart_items WHERE cart_id = ?
+        ON CONFLICT (cart_id, product_id)
+        DO UPDATE SET quantity = ` + quantity
+
+	if _, err := tx.Exec(query, toCartID, fromCartID); err != nil {
+		log.Error("Error merging cart items: ", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteStaleGuestCarts deletes guest carts that were not changed within GuestCartTTL.
+func DeleteStaleGuestCarts() error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	staleCarts := `SELECT id FROM cart WHERE guest_id IS NOT NULL AND updated_at < NOW() - CAST(? AS interval)`
+	_, err = tx.Exec(`DELETE FROM cart_items WHERE cart_id IN (`+staleCarts+`)`, GuestCartTTL.String())
+	if err != nil {
+		log.Error("Error deleting stale guest cart items: ", err)
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM cart WHERE id IN (`+staleCarts+`)`, GuestCartTTL.String())
+	if err != nil {
+		log.Error("Error deleting stale guest carts: ", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Infof("Deleted %d stale guest carts", deleted)
+	}
+
+	return nil
+}
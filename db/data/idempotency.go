@@ -0,0 +1,109 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/labstack/gommon/log"
+)
+
+// IdempotencyKeyTTL is how long the response of an idempotent request is kept for replay.
+var IdempotencyKeyTTL = 24 * time.Hour
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is still in progress")
+)
+
+// IdempotentResponse is the stored response of the first request sent with a key.
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        string
+}
+
+// ClaimIdempotencyKey reserves the key for a request of the user.
+// It returns nil if the request should be processed, or the stored response
+// if the same request was already completed. Expired keys can be claimed again.
+func ClaimIdempotencyKey(userID string, key string, requestHash string) (*IdempotentResponse, error) {
+	claimQuery := `
+	INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at)
+	VALUES (?, ?, ?, NOW() + CAST(? AS interval))
+	ON CONFLICT (user_id, key) DO UPDATE
+	SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL,
+		created_at = NOW(), expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at <= NOW()
+	`
+	result, err := db.Proxy.GetPrimaryDB().Exec(claimQuery, userID, key, requestHash, IdempotencyKeyTTL.String())
+	if err != nil {
+		log.Error("Error claiming idempotency key: ", err)
+		return nil, err
+	}
+	if claimed, _ := result.RowsAffected(); claimed > 0 {
+		return nil, nil
+	}
+
+	// Ключ уже использован: сверяем запрос и отдаём сохранённый ответ
+	var storedHash string
+	var statusCode *int
+	var contentType, body *string
+	storedQuery := `SELECT request_hash, status_code, content_type, response_body FROM idempotency_keys WHERE user_id = ? AND key = ?`
+	err = db.Proxy.GetPrimaryDB().QueryRow(storedQuery, userID, key).Scan(&storedHash, &statusCode, &contentType, &body)
+	if err != nil {
+		log.Error("Error fetching idempotency key: ", err)
+		return nil, err
+	}
+
+	if storedHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if statusCode == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	response := &IdempotentResponse{StatusCode: *statusCode}
+	if contentType != nil {
+		response.ContentType = *contentType
+	}
+	if body != nil {
+		response.Body = *body
+	}
+	return response, nil
+}
+
+// SaveIdempotentResponse stores the response of a claimed key for replay.
+func SaveIdempotentResponse(userID string, key string, response IdempotentResponse) error {
+	query := `UPDATE idempotency_keys SET status_code = ?, content_type = ?, response_body = ? WHERE user_id = ? AND key = ?`
+	_, err := db.Proxy.GetPrimaryDB().Exec(query, response.StatusCode, response.ContentType, response.Body, userID, key)
+	if err != nil {
+		log.Error("Error saving idempotent response: ", err)
+		return err
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a claimed key, so the request can be retried.
+func ReleaseIdempotencyKey(userID string, key string) error {
+	_, err := db.Proxy.GetPrimaryDB().Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND status_code IS NULL`, userID, key)
+	if err != nil {
+		log.Error("Error releasing idempotency key: ", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes keys whose TTL has passed.
+func DeleteExpiredIdempotencyKeys() error {
+	result, err := db.Proxy.GetPrimaryDB().Exec(`DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		log.Error("Error deleting expired idempotency keys: ", err)
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Infof("Deleted %d expired idempotency keys", deleted)
+	}
+
+	return nil
+}
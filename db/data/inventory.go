@@ -0,0 +1,100 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+type InsufficientStockItem struct {
+	ProductID int    `json:"product_id"`
+	Name      string `json:"name"`
+	Requested int    `json:"requested"`
+	Available int    `json:"available"`
+}
+
+// InsufficientStockError is returned when an order asks for more items than are in stock.
+type InsufficientStockError struct {
+	Items []InsufficientStockItem
+}
+
+func (err *InsufficientStockError) Error() string {
+	names := make([]string, len(err.Items))
+	for i, item := range err.Items {
+		names[i] = item.Name
+	}
+	return "insufficient stock: " + strings.Join(names, ", ")
+}
+
+// takeStockForCart decrements stock for every item in the user's cart.
+// Product rows are locked in id order, so concurrent orders wait for each other
+// instead of deadlocking, and nothing is changed if any item is short.
+// Stock reserved in other users' carts is not available to this order.
+func takeStockForCart(tx bun.Tx, userID string) error {
+	lockQuery := `
+	SELECT p.id, p.name, p.stock - ` + reservedByOthers + `, ci.quantity
+	FROM cart_items ci
+	JOIN cart c ON ci.cart_id = c.id
+	JOIN products p ON ci.product_id = p.id
+	WHERE c.user_id = ?
+	ORDER BY p.id
+	FOR UPDATE OF p
+	`
+	rows, err := tx.Query(lockQuery, userID, userID)
+	if err != nil {
+		log.Error("Error locking products: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	var short []InsufficientStockItem
+	for rows.Next() {
+		var item InsufficientStockItem
+		if err := rows.Scan(&item.ProductID, &item.Name, &item.Available, &item.Requested); err != nil {
+			log.Error("Error scanning product stock: ", err)
+			return err
+		}
+		if item.Available < item.Requested {
+			item.Name = strings.TrimSpace(item.Name)
+			short = append(short, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return err
+	}
+	if len(short) > 0 {
+		return &InsufficientStockError{Items: short}
+	}
+
+	updateQuery := `
+	UPDATE products p
+	SET stock = p.stock - ci.quantity
+	FROM cart_items ci
+	JOIN cart c ON ci.cart_id = c.id
+	WHERE ci.product_id = p.id AND c.user_id = ?
+	`
+	if _, err := tx.Exec(updateQuery, userID); err != nil {
+		log.Error("Error decrementing stock: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// restockOrder puts the items of an order back into stock.
+func restockOrder(tx bun.Tx, orderID int) error {
+	query := `
+	UPDATE products p
+	SET stock = p.stock + oi.quantity
+	FROM order_items oi
+	WHERE oi.product_id = p.id AND oi.order_id = ?
+	`
+	if _, err := tx.Exec(query, orderID); err != nil {
+		log.Error("Error restocking order items: ", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,409 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+const (
+	DefaultOrderPageSize = 20
+	MaxOrderPageSize     = 100
+)
+
+// Order statuses.
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+	OrderStatusFailed    = "failed"
+)
+
+// Who changed the status of an order.
+const (
+	OrderActorCustomer = "customer"
+	OrderActorAdmin    = "admin"
+	OrderActorSystem   = "system"
+)
+
+var (
+	ErrOrderNotFound          = errors.New("order not found")
+	ErrInvalidOrderStatus     = errors.New("invalid order status")
+	ErrInvalidOrderTransition = errors.New("order status cannot change this way")
+	ErrOrderNotCancellable    = errors.New("order can no longer be cancelled")
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCancelled, OrderStatusFailed},
+	OrderStatusPaid:      {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusDelivered},
+	OrderStatusDelivered: {OrderStatusRefunded},
+	OrderStatusCancelled: {},
+	OrderStatusRefunded:  {},
+	OrderStatusFailed:    {},
+}
+
+// OrderFilter holds the options of the order history listing.
+// From and To bound the order date, To is exclusive.
+type OrderFilter struct {
+	Status string
+	From   *time.Time
+	To     *time.Time
+	Cursor int
+	Limit  int
+}
+
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor int     `json:"next_cursor,omitempty"`
+	HasMore    bool    `json:"has_more"`
+}
+
+type OrderEvent struct {
+	ID         int       `json:"id"`
+	FromStatus *string   `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// IsOrderStatus reports whether status is a known order status.
+func IsOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+// CanTransitionOrder reports whether an order may move from one status to another.
+func CanTransitionOrder(from string, to string) bool {
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOrderStatus moves an order to a new status on behalf of an admin.
+// Cancelling or refunding an order that has not shipped returns its items to stock.
+func SetOrderStatus(orderID int, status string, note string) error {
+	if !IsOrderStatus(status) {
+		return ErrInvalidOrderStatus
+	}
+
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	from, err := lockOrderStatus(tx, orderID)
+	if err != nil {
+		return err
+	}
+	if err = transitionOrder(tx, orderID, from, status, OrderActorAdmin, note); err != nil {
+		return err
+	}
+	if err = releaseOrder(tx, orderID, from, status); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func GetOrderEvents(orderID int) ([]OrderEvent, error) {
+	events := []OrderEvent{}
+	query := `
+	SELECT id, from_status, to_status, actor, note, created_at
+	FROM order_events
+	WHERE order_id = ?
+	ORDER BY id
+	`
+	rows, err := db.Proxy.GetCurrentDB().Query(query, orderID)
+	if err != nil {
+		log.Error("Error fetching order events: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event OrderEvent
+		if err := rows.Scan(&event.ID, &event.FromStatus, &event.ToStatus, &event.Actor, &event.Note, &event.CreatedAt); err != nil {
+			log.Error("Error scanning order event: ", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// lockOrderStatus locks the order row and returns its current status.
+func lockOrderStatus(tx bun.Tx, orderID int) (string, error) {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM orders WHERE id = ? FOR UPDATE`, orderID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrOrderNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching order status: ", err)
+		return "", err
+	}
+	return status, nil
+}
+
+// transitionOrder checks the transition, updates the order status, records the event
+// and writes it to the outbox. The order row must be locked by the caller.
+func transitionOrder(tx bun.Tx, orderID int, from string, to string, actor string, note string) error {
+	if !CanTransitionOrder(from, to) {
+		return ErrInvalidOrderTransition
+	}
+
+	if _, err := tx.Exec(`UPDATE orders SET status = ? WHERE id = ?`, to, orderID); err != nil {
+		log.Error("Error updating order status: ", err)
+		return err
+	}
+
+	if err := recordOrderEvent(tx, orderID, &from, to, actor, note); err != nil {
+		return err
+	}
+	return enqueueOrderEvent(tx, OrderStatusChange{OrderID: orderID, FromStatus: from, ToStatus: to, Actor: actor, Note: note})
+}
+
+func recordOrderEvent(tx bun.Tx, orderID int, from *string, to string, actor string, note string) error {
+	query := `INSERT INTO order_events (order_id, from_status, to_status, actor, note) VALUES (?, ?, ?, ?, ?)`
+	if _, err := tx.Exec(query, orderID, from, to, actor, note); err != nil {
+		log.Error("Error recording order event: ", err)
+		return err
+	}
+	return nil
+}
+
+// releaseOrder returns stock and the coupon use of an order that was called off before shipping.
+func releaseOrder(tx bun.Tx, orderID int, from string, to string) error {
+	if from != OrderStatusPending && from != OrderStatusPaid {
+		return nil
+	}
+	if to != OrderStatusCancelled && to != OrderStatusRefunded && to != OrderStatusFailed {
+		return nil
+	}
+
+	if err := restockOrder(tx, orderID); err != nil {
+		return err
+	}
+	return releaseCouponRedemption(tx, orderID)
+}
+
+const orderColumns = `o.id, o.delivery_address, o.order_date, o.status, coalesce(o.currency, ''), coalesce(o.exchange_rate, 1),
+	coalesce(o.region, ''), o.discount, o.shipping, o.tax, o.total, o.shipping_address`
+
+// GetOrders returns one page of the user's orders, newest first.
+// The next page starts after the order with the id passed as cursor.
+func GetOrders(userID string, filter OrderFilter) (OrderPage, error) {
+	page := OrderPage{Orders: []Order{}}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultOrderPageSize
+	}
+	if filter.Limit > MaxOrderPageSize {
+		filter.Limit = MaxOrderPageSize
+	}
+
+	conditions := []string{"o.user_id = ?"}
+	args := []interface{}{userID}
+	if filter.Status != "" {
+		conditions = append(conditions, "o.status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "o.order_date >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "o.order_date < ?")
+		args = append(args, *filter.To)
+	}
+	if filter.Cursor > 0 {
+		conditions = append(conditions, "o.id < ?")
+		args = append(args, filter.Cursor)
+	}
+
+	query := `SELECT ` + orderColumns + ` FROM orders o WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY o.id DESC LIMIT ?`
+	orders, err := queryOrders(query, append(args, filter.Limit+1)...)
+	if err != nil {
+		return page, err
+	}
+
+	if len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+		page.HasMore = true
+		page.NextCursor = orders[filter.Limit-1].ID
+	}
+	page.Orders = orders
+
+	return page, nil
+}
+
+// GetOrder returns an order of the user with its items.
+func GetOrder(userID string, orderID int) (Order, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders o WHERE o.id = ? AND o.user_id = ?`
+	orders, err := queryOrders(query, orderID, userID)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(orders) == 0 {
+		return Order{}, ErrOrderNotFound
+	}
+	return orders[0], nil
+}
+
+// queryOrders runs a query selecting orderColumns and loads the items of all
+// returned orders with a single query.
+func queryOrders(query string, args ...interface{}) ([]Order, error) {
+	rows, err := db.Proxy.GetCurrentDB().Query(query, args...)
+	if err != nil {
+		log.Error("Error fetching orders: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []Order
+	var rates []string
+	var currencies []string
+	var totals []*money.Money
+	for rows.Next() {
+		var order Order
+		var currency, rate string
+		var total *money.Money
+		var address []byte
+		err := rows.Scan(&order.ID, &order.DeliveryAddress, &order.OrderDate, &order.Status, &currency, &rate,
+			&order.Region, &order.Discount, &order.Shipping, &order.Tax, &total, &address)
+		if err != nil {
+			log.Error("Error scanning order: ", err)
+			return nil, err
+		}
+		order.DeliveryAddress = strings.TrimSpace(order.DeliveryAddress)
+		if address != nil {
+			if err := json.Unmarshal(address, &order.Address); err != nil {
+				log.Error("Error decoding order address: ", err)
+				return nil, err
+			}
+		}
+
+		orders = append(orders, order)
+		currencies = append(currencies, currency)
+		rates = append(rates, rate)
+		totals = append(totals, total)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return orders, nil
+	}
+
+	ids := make([]int, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	items, err := getOrderItems(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		order := &orders[i]
+		order.CartItems = items[order.ID]
+
+		// Цены заказа показываются в валюте и по курсу на момент оформления
+		order.ExchangeRate = money.BaseRate()
+		if currencies[i] != "" {
+			if order.ExchangeRate, err = money.ParseRate(rates[i], currencies[i]); err != nil {
+				log.Error("Error parsing order exchange rate: ", err)
+				return nil, err
+			}
+		}
+		for j := range order.CartItems {
+			order.CartItems[j].Price = order.CartItems[j].Price.Convert(order.ExchangeRate)
+		}
+		if order.Subtotal, err = CartTotal(order.CartItems, order.ExchangeRate.Currency); err != nil {
+			log.Error("Error calculating order subtotal: ", err)
+			return nil, err
+		}
+		order.Discount = order.Discount.Convert(order.ExchangeRate)
+		order.Shipping = order.Shipping.Convert(order.ExchangeRate)
+		order.Tax = order.Tax.Convert(order.ExchangeRate)
+		// Итог — списанная сумма, сконвертированная один раз; заказы до её
+		// сохранения складывают итог из частей
+		if totals[i] != nil {
+			order.TotalPrice = totals[i].Convert(order.ExchangeRate)
+			continue
+		}
+		order.TotalPrice, err = money.Sum(order.Subtotal, order.Discount.Neg(), order.Shipping, order.Tax)
+		if err != nil {
+			log.Error("Error calculating order total: ", err)
+			return nil, err
+		}
+	}
+
+	return orders, nil
+}
+
+// getOrderItems returns the items of the orders grouped by order id.
+func getOrderItems(orderIDs []int) (map[int][]CartItem, error) {
+	items := make(map[int][]CartItem, len(orderIDs))
+	query := `
+	SELECT oi.order_id, p.id, p.name, oi.price_at_order, oi.quantity
+	FROM order_items oi
+	JOIN products p ON oi.product_id = p.id
+	WHERE oi.order_id IN (?)
+	ORDER BY oi.order_id, p.id
+	`
+	rows, err := db.Proxy.GetCurrentDB().Query(query, bun.In(orderIDs))
+	if err != nil {
+		log.Error("Error fetching order items: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderID int
+		var item CartItem
+		if err := rows.Scan(&orderID, &item.ProductID, &item.Product, &item.Price, &item.Quantity); err != nil {
+			log.Error("Error scanning order item: ", err)
+			return nil, err
+		}
+		item.Product = strings.TrimSpace(item.Product)
+		items[orderID] = append(items[orderID], item)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/Lexxxzy/go-echo-template/outbox"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// Topics of the events written to the outbox.
+const (
+	TopicOrderPlaced        = "order.placed"
+	TopicOrderCancelled     = "order.cancelled"
+	TopicOrderStatusChanged = "order.status_changed"
+)
+
+var (
+	// OutboxBatchSize is how many events one run of the relay publishes at most.
+	OutboxBatchSize = 100
+	// OutboxMaxBackoff caps the delay before a failed event is retried.
+	OutboxMaxBackoff = time.Hour
+	// OutboxRetention is how long published events are kept.
+	OutboxRetention = 7 * 24 * time.Hour
+	// OutboxClaimTimeout is how long a relay may take to publish the events it claimed
+	// before another relay claims them again.
+	OutboxClaimTimeout = time.Minute
+)
+
+// OrderStatusChange is the payload of order.status_changed and order.cancelled events.
+type OrderStatusChange struct {
+	OrderID    int    `json:"order_id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	Actor      string `json:"actor"`
+	Note       string `json:"note,omitempty"`
+}
+
+// OrderPlaced is the payload of order.placed events. Amounts are in the base currency;
+// Currency and ExchangeRate are what the customer saw the prices in.
+type OrderPlaced struct {
+	OrderID         int               `json:"order_id"`
+	UserID          string            `json:"user_id"`
+	Status          string            `json:"status"`
+	DeliveryAddress string            `json:"delivery_address"`
+	Address         *Address          `json:"address,omitempty"`
+	Region          string            `json:"region,omitempty"`
+	Currency        string            `json:"currency"`
+	ExchangeRate    string            `json:"exchange_rate"`
+	Items           []OrderPlacedItem `json:"items"`
+	Subtotal        money.Money       `json:"subtotal"`
+	Discount        money.Money       `json:"discount"`
+	Shipping        money.Money       `json:"shipping"`
+	Tax             money.Money       `json:"tax"`
+	Total           money.Money       `json:"total"`
+	PaymentID       string            `json:"payment_id"`
+}
+
+type OrderPlacedItem struct {
+	ProductID int         `json:"product_id"`
+	Quantity  int         `json:"quantity"`
+	Price     money.Money `json:"price"`
+	Discount  money.Money `json:"discount"`
+}
+
+// enqueueEvent writes an event to the outbox in the transaction of the change it describes.
+func enqueueEvent(tx bun.Tx, topic string, key string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error("Error encoding outbox event: ", err)
+		return err
+	}
+
+	query := `INSERT INTO outbox (topic, key, payload) VALUES (?, ?, CAST(? AS jsonb))`
+	if _, err := tx.Exec(query, topic, key, string(body)); err != nil {
+		log.Error("Error writing outbox event: ", err)
+		return err
+	}
+	return nil
+}
+
+// enqueueOrderEvent writes the event of an order status change, and an
+// order.cancelled event if the order was cancelled.
+func enqueueOrderEvent(tx bun.Tx, change OrderStatusChange) error {
+	key := strconv.Itoa(change.OrderID)
+	if err := enqueueEvent(tx, TopicOrderStatusChanged, key, change); err != nil {
+		return err
+	}
+	if change.ToStatus == OrderStatusCancelled {
+		return enqueueEvent(tx, TopicOrderCancelled, key, change)
+	}
+	return nil
+}
+
+// enqueueOrderPlaced writes the order.placed event of a new order with its items.
+func enqueueOrderPlaced(tx bun.Tx, placed OrderPlaced) error {
+	rows, err := tx.Query(`SELECT product_id, quantity, price_at_order, discount FROM order_items WHERE order_id = ? ORDER BY product_id`, placed.OrderID)
+	if err != nil {
+		log.Error("Error fetching order items: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	placed.Items = []OrderPlacedItem{}
+	for rows.Next() {
+		var item OrderPlacedItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity, &item.Price, &item.Discount); err != nil {
+			log.Error("Error scanning order item: ", err)
+			return err
+		}
+		placed.Items = append(placed.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return err
+	}
+	rows.Close()
+
+	return enqueueEvent(tx, TopicOrderPlaced, strconv.Itoa(placed.OrderID), placed)
+}
+
+// RelayOutbox publishes a batch of due events to the sink.
+// Events are claimed in a short transaction and published after it commits, so
+// several instances can relay at once without holding locks during sink calls.
+// An event is only claimed once the older events with its key are published,
+// so events of one key reach the sink in the order they were written.
+// An event that fails is retried later with exponential backoff until it succeeds,
+// so sinks may see an event more than once.
+func RelayOutbox(ctx context.Context, sink outbox.Sink) error {
+	messages, attempts, err := claimOutboxEvents()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, message := range messages {
+		if publishErr := sink.Publish(ctx, message); publishErr != nil {
+			failed++
+			backoff := outboxBackoff(attempts[i] + 1)
+			retryQuery := `
+			UPDATE outbox
+			SET attempts = attempts + 1, last_error = ?, next_attempt_at = NOW() + CAST(? AS interval), claimed_until = NULL
+			WHERE id = ?
+			`
+			if _, err := db.Proxy.GetPrimaryDB().Exec(retryQuery, publishErr.Error(), backoff.String(), message.ID); err != nil {
+				log.Error("Error scheduling outbox retry: ", err)
+				return err
+			}
+			continue
+		}
+
+		publishedQuery := `UPDATE outbox SET attempts = attempts + 1, published_at = NOW(), claimed_until = NULL WHERE id = ?`
+		if _, err := db.Proxy.GetPrimaryDB().Exec(publishedQuery, message.ID); err != nil {
+			log.Error("Error marking outbox event published: ", err)
+			return err
+		}
+	}
+
+	if failed > 0 {
+		log.Warnf("Failed to publish %d of %d outbox events to %s", failed, len(messages), sink.Name())
+	}
+	return nil
+}
+
+// claimOutboxEvents claims due events for OutboxClaimTimeout, skipping events
+// with an older unpublished event of the same key. A relay that dies while
+// publishing leaves its claims to expire, after which the events are claimed again.
+func claimOutboxEvents() ([]outbox.Message, []int, error) {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT o.id, o.topic, o.key, o.payload, o.created_at, o.attempts
+	FROM outbox o
+	WHERE o.published_at IS NULL AND o.next_attempt_at <= NOW()
+	AND (o.claimed_until IS NULL OR o.claimed_until <= NOW())
+	AND NOT EXISTS (
+		SELECT 1 FROM outbox older
+		WHERE older.key = o.key AND older.published_at IS NULL AND older.id < o.id
+	)
+	ORDER BY o.id
+	LIMIT ?
+	FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.Query(query, OutboxBatchSize)
+	if err != nil {
+		log.Error("Error fetching outbox events: ", err)
+		return nil, nil, err
+	}
+
+	var messages []outbox.Message
+	var attempts []int
+	var ids []int64
+	for rows.Next() {
+		var message outbox.Message
+		var attempt int
+		var payload []byte
+		if err := rows.Scan(&message.ID, &message.Topic, &message.Key, &payload, &message.CreatedAt, &attempt); err != nil {
+			rows.Close()
+			log.Error("Error scanning outbox event: ", err)
+			return nil, nil, err
+		}
+		message.Payload = payload
+		messages = append(messages, message)
+		attempts = append(attempts, attempt)
+		ids = append(ids, message.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+
+	claimQuery := `UPDATE outbox SET claimed_until = NOW() + CAST(? AS interval) WHERE id IN (?)`
+	if _, err := tx.Exec(claimQuery, OutboxClaimTimeout.String(), bun.In(ids)); err != nil {
+		log.Error("Error claiming outbox events: ", err)
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return nil, nil, err
+	}
+
+	return messages, attempts, nil
+}
+
+// DeletePublishedOutboxEvents removes published events older than OutboxRetention.
+func DeletePublishedOutboxEvents() error {
+	query := `DELETE FROM outbox WHERE published_at <= NOW() - CAST(? AS interval)`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query, OutboxRetention.String())
+	if err != nil {
+		log.Error("Error deleting published outbox events: ", err)
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Infof("Deleted %d published outbox events", deleted)
+	}
+
+	return nil
+}
+
+// outboxBackoff is the delay before the next attempt after the given number of attempts.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return OutboxMaxBackoff
+	}
+	backoff := time.Duration(1<<attempts) * time.Second
+	if backoff > OutboxMaxBackoff {
+		return OutboxMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,484 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/google/uuid"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// Payment statuses. A partially refunded payment stays captured.
+const (
+	PaymentPending    = "pending"
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentFailed     = "failed"
+	PaymentRefunded   = "refunded"
+)
+
+var (
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrPaymentNotCaptured   = errors.New("payment is not captured")
+	ErrRefundExceedsPayment = errors.New("refund exceeds the paid amount")
+)
+
+// RefundRetryDelay is how long a refund stays pending before it is retried, so
+// that the request that started it has time to finish it.
+var RefundRetryDelay = time.Minute
+
+type Payment struct {
+	ID             string      `json:"id"`
+	OrderID        int         `json:"order_id"`
+	Provider       string      `json:"provider,omitempty"`
+	Reference      string      `json:"-"`
+	Amount         money.Money `json:"amount"`
+	RefundedAmount money.Money `json:"refunded_amount"`
+	Status         string      `json:"status"`
+	FailureReason  string      `json:"failure_reason,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+// Refundable is the captured amount that has not been refunded yet.
+func (payment Payment) Refundable() (money.Money, error) {
+	if payment.Status != PaymentCaptured {
+		return money.New(0, payment.Amount.Currency), nil
+	}
+	return payment.Amount.Sub(payment.RefundedAmount)
+}
+
+const paymentColumns = `id, order_id, coalesce(provider, ''), coalesce(reference, ''), amount, refunded_amount, status, failure_reason, created_at`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (Payment, error) {
+	var payment Payment
+	err := row.Scan(&payment.ID, &payment.OrderID, &payment.Provider, &payment.Reference, &payment.Amount,
+		&payment.RefundedAmount, &payment.Status, &payment.FailureReason, &payment.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return payment, ErrPaymentNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching payment: ", err)
+	}
+	return payment, err
+}
+
+func GetOrderPayment(orderID int) (Payment, error) {
+	return scanPayment(db.Proxy.GetPrimaryDB().QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE order_id = ?`, orderID))
+}
+
+// createPayment creates the pending payment of a new order.
+func createPayment(tx bun.Tx, orderID int, amount money.Money) (Payment, error) {
+	payment := Payment{
+		ID:             uuid.NewString(),
+		OrderID:        orderID,
+		Amount:         amount,
+		RefundedAmount: money.New(0, amount.Currency),
+		Status:         PaymentPending,
+	}
+
+	query := `INSERT INTO payments (id, order_id, amount, status) VALUES (?, ?, ?, ?) RETURNING created_at`
+	if err := tx.QueryRow(query, payment.ID, orderID, amount, payment.Status).Scan(&payment.CreatedAt); err != nil {
+		log.Error("Error creating payment: ", err)
+		return payment, err
+	}
+
+	return payment, nil
+}
+
+// SetPaymentReference saves the provider and its id of the payment after authorization was requested.
+func SetPaymentReference(paymentID string, provider string, reference string) error {
+	query := `UPDATE payments SET provider = ?, reference = ?, updated_at = NOW() WHERE id = ?`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query, provider, reference, paymentID)
+	if err != nil {
+		log.Error("Error saving payment reference: ", err)
+		return err
+	}
+	return expectAffected(result, ErrPaymentNotFound)
+}
+
+// AuthorizePayment records that the provider authorized the payment and returns it.
+// A payment left in the authorized status still has to be captured. If the order
+// was cancelled in the meantime, the payment fails instead and is never captured.
+// Repeated calls for the same payment are safe.
+func AuthorizePayment(paymentID string) (Payment, error) {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return Payment{}, err
+	}
+	defer tx.Rollback()
+
+	payment, err := lockPayment(tx, paymentID)
+	if err != nil || payment.Status != PaymentPending {
+		return payment, err
+	}
+
+	orderStatus, err := lockOrderStatus(tx, payment.OrderID)
+	if err != nil {
+		return payment, err
+	}
+
+	payment.Status = PaymentAuthorized
+	if orderStatus != OrderStatusPending {
+		payment.Status, payment.FailureReason = PaymentFailed, "order is "+orderStatus
+	}
+	query := `UPDATE payments SET status = ?, failure_reason = ?, updated_at = NOW() WHERE id = ?`
+	if _, err = tx.Exec(query, payment.Status, payment.FailureReason, paymentID); err != nil {
+		log.Error("Error updating payment: ", err)
+		return payment, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return payment, err
+	}
+
+	return payment, nil
+}
+
+// CapturePayment records that the authorized payment was captured and marks the order paid.
+// It reports false if the order was cancelled while the payment was being captured,
+// in which case the caller has to refund the payment.
+func CapturePayment(paymentID string) (bool, error) {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	payment, err := lockPayment(tx, paymentID)
+	if err != nil || payment.Status != PaymentAuthorized {
+		return true, err
+	}
+
+	query := `UPDATE payments SET status = ?, updated_at = NOW() WHERE id = ?`
+	if _, err = tx.Exec(query, PaymentCaptured, paymentID); err != nil {
+		log.Error("Error updating payment: ", err)
+		return false, err
+	}
+
+	orderStatus, err := lockOrderStatus(tx, payment.OrderID)
+	if err != nil {
+		return false, err
+	}
+	paid := orderStatus == OrderStatusPending
+	if paid {
+		if err = transitionOrder(tx, payment.OrderID, orderStatus, OrderStatusPaid, OrderActorSystem, ""); err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return false, err
+	}
+
+	return paid, nil
+}
+
+// FailPayment records a declined payment. A pending order moves to failed and
+// its stock and coupon use are returned.
+func FailPayment(paymentID string, reason string) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	payment, err := lockPayment(tx, paymentID)
+	if err != nil {
+		return err
+	}
+	if payment.Status != PaymentPending && payment.Status != PaymentAuthorized {
+		return nil
+	}
+
+	query := `UPDATE payments SET status = ?, failure_reason = ?, updated_at = NOW() WHERE id = ?`
+	if _, err = tx.Exec(query, PaymentFailed, reason, paymentID); err != nil {
+		log.Error("Error updating payment: ", err)
+		return err
+	}
+
+	orderStatus, err := lockOrderStatus(tx, payment.OrderID)
+	if err != nil {
+		return err
+	}
+	if orderStatus == OrderStatusPending {
+		if err = transitionOrder(tx, payment.OrderID, orderStatus, OrderStatusFailed, OrderActorSystem, reason); err != nil {
+			return err
+		}
+		if err = releaseOrder(tx, payment.OrderID, orderStatus, OrderStatusFailed); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// Refund statuses. A pending refund may or may not have reached the provider yet.
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+)
+
+// PaymentRefund is a refund of a payment. Key is sent to the provider as the
+// idempotency key, so retrying a refund never returns the money twice.
+type PaymentRefund struct {
+	ID        int         `json:"id"`
+	PaymentID string      `json:"payment_id"`
+	Reference string      `json:"-"`
+	Key       string      `json:"key"`
+	ReturnID  *int        `json:"return_id,omitempty"`
+	Amount    money.Money `json:"amount"`
+	Status    string      `json:"status"`
+}
+
+// StartPaymentRefund stores a pending refund of the order's captured payment
+// under the key, of the amount or of everything not refunded yet if amount is nil.
+// If a refund with the key exists, it is returned instead.
+func StartPaymentRefund(orderID int, key string, amount *money.Money) (PaymentRefund, error) {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return PaymentRefund{}, err
+	}
+	defer tx.Rollback()
+
+	refund, err := startPaymentRefund(tx, orderID, key, amount, nil)
+	if err != nil {
+		return refund, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return refund, err
+	}
+
+	return refund, nil
+}
+
+// CompletePaymentRefund records that the provider made the refund with the key.
+// Completing a refund again does nothing.
+func CompletePaymentRefund(key string) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = completePaymentRefund(tx, key); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetPendingRefunds lists refunds that have been pending for longer than
+// RefundRetryDelay, oldest first. Their provider call failed or never finished.
+func GetPendingRefunds() ([]PaymentRefund, error) {
+	refunds := []PaymentRefund{}
+
+	query := `
+	SELECT r.id, r.payment_id, coalesce(p.reference, ''), r.key, r.return_id, r.amount, r.status
+	FROM payment_refunds r
+	JOIN payments p ON p.id = r.payment_id
+	WHERE r.status = ? AND r.created_at <= NOW() - CAST(? AS interval)
+	ORDER BY r.id
+	`
+	rows, err := db.Proxy.GetPrimaryDB().Query(query, RefundPending, RefundRetryDelay.String())
+	if err != nil {
+		log.Error("Error fetching pending refunds: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var refund PaymentRefund
+		err := rows.Scan(&refund.ID, &refund.PaymentID, &refund.Reference, &refund.Key, &refund.ReturnID, &refund.Amount, &refund.Status)
+		if err != nil {
+			log.Error("Error scanning refund: ", err)
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// GetOrdersAwaitingRefund lists cancelled and refunded orders whose captured
+// payment has no order refund yet, because the refund was never started.
+func GetOrdersAwaitingRefund() ([]int, error) {
+	orderIDs := []int{}
+
+	query := `
+	SELECT o.id
+	FROM orders o
+	JOIN payments p ON p.order_id = o.id
+	WHERE o.status IN (?, ?) AND p.status = ? AND p.updated_at <= NOW() - CAST(? AS interval)
+	AND NOT EXISTS (SELECT 1 FROM payment_refunds r WHERE r.key = 'order-' || o.id)
+	ORDER BY o.id
+	`
+	rows, err := db.Proxy.GetPrimaryDB().Query(query, OrderStatusCancelled, OrderStatusRefunded, PaymentCaptured, RefundRetryDelay.String())
+	if err != nil {
+		log.Error("Error fetching orders awaiting refund: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Error("Error scanning order id: ", err)
+			return nil, err
+		}
+		orderIDs = append(orderIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return orderIDs, nil
+}
+
+func startPaymentRefund(tx bun.Tx, orderID int, key string, amount *money.Money, returnID *int) (PaymentRefund, error) {
+	payment, err := scanPayment(tx.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE order_id = ? FOR UPDATE`, orderID))
+	if err != nil {
+		return PaymentRefund{}, err
+	}
+
+	refund := PaymentRefund{PaymentID: payment.ID, Reference: payment.Reference, Key: key, ReturnID: returnID}
+	existingQuery := `SELECT id, return_id, amount, status FROM payment_refunds WHERE key = ?`
+	err = tx.QueryRow(existingQuery, key).Scan(&refund.ID, &refund.ReturnID, &refund.Amount, &refund.Status)
+	if err == nil {
+		return refund, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Error("Error fetching refund: ", err)
+		return refund, err
+	}
+
+	if payment.Status != PaymentCaptured {
+		return refund, ErrPaymentNotCaptured
+	}
+
+	// Ещё не завершённые возвраты уже зарезервировали часть суммы
+	var pending money.Money
+	pendingQuery := `SELECT coalesce(sum(amount), 0) FROM payment_refunds WHERE payment_id = ? AND status = ?`
+	if err = tx.QueryRow(pendingQuery, payment.ID, RefundPending).Scan(&pending); err != nil {
+		log.Error("Error fetching pending refunds: ", err)
+		return refund, err
+	}
+	refundable, err := payment.Refundable()
+	if err == nil {
+		refundable, err = refundable.Sub(pending)
+	}
+	if err != nil {
+		log.Error("Error calculating refundable amount: ", err)
+		return refund, err
+	}
+
+	refund.Amount, refund.Status = refundable, RefundPending
+	if amount != nil {
+		if amount.Amount > refundable.Amount {
+			return refund, ErrRefundExceedsPayment
+		}
+		refund.Amount = *amount
+	}
+
+	query := `INSERT INTO payment_refunds (payment_id, key, return_id, amount, status) VALUES (?, ?, ?, ?, ?) RETURNING id`
+	if err = tx.QueryRow(query, payment.ID, key, returnID, refund.Amount, refund.Status).Scan(&refund.ID); err != nil {
+		log.Error("Error saving refund: ", err)
+		return refund, err
+	}
+
+	return refund, nil
+}
+
+// completePaymentRefund adds a pending refund to its payment. The payment becomes
+// refunded once the whole amount is returned.
+func completePaymentRefund(tx bun.Tx, key string) error {
+	var refundID int
+	var paymentID, status string
+	var amount money.Money
+	query := `SELECT id, payment_id, amount, status FROM payment_refunds WHERE key = ? FOR UPDATE`
+	err := tx.QueryRow(query, key).Scan(&refundID, &paymentID, &amount, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching refund: ", err)
+		return err
+	}
+	if status == RefundSucceeded {
+		return nil
+	}
+
+	payment, err := lockPayment(tx, paymentID)
+	if err != nil {
+		return err
+	}
+	if payment.Status != PaymentCaptured {
+		return ErrPaymentNotCaptured
+	}
+	refundable, err := payment.Refundable()
+	if err != nil {
+		log.Error("Error calculating refundable amount: ", err)
+		return err
+	}
+	if amount.Amount > refundable.Amount {
+		return ErrRefundExceedsPayment
+	}
+
+	refunded, err := payment.RefundedAmount.Add(amount)
+	if err != nil {
+		log.Error("Error calculating refunded amount: ", err)
+		return err
+	}
+	paymentStatus := PaymentCaptured
+	if refunded.Amount == payment.Amount.Amount {
+		paymentStatus = PaymentRefunded
+	}
+
+	paymentQuery := `UPDATE payments SET refunded_amount = ?, status = ?, updated_at = NOW() WHERE id = ?`
+	if _, err = tx.Exec(paymentQuery, refunded, paymentStatus, paymentID); err != nil {
+		log.Error("Error recording refund: ", err)
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE payment_refunds SET status = ?, completed_at = NOW() WHERE id = ?`, RefundSucceeded, refundID); err != nil {
+		log.Error("Error completing refund: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func lockPayment(tx bun.Tx, paymentID string) (Payment, error) {
+	if _, err := uuid.Parse(paymentID); err != nil {
+		return Payment{}, ErrPaymentNotFound
+	}
+	return scanPayment(tx.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = ? FOR UPDATE`, paymentID))
+}
@@ -0,0 +1,234 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+)
+
+// Statuses of a scheduled price change.
+const (
+	PriceChangeScheduled = "scheduled"
+	PriceChangeActive    = "active"
+	PriceChangeCompleted = "completed"
+	PriceChangeCancelled = "cancelled"
+)
+
+var (
+	ErrPriceChangeNotFound = errors.New("price change not found")
+	ErrPriceChangeOverlaps = errors.New("price change overlaps another one")
+)
+
+type PriceHistoryEntry struct {
+	Price     money.Money `bun:"type:decimal(10,2),notnull" json:"price"`
+	ValidFrom time.Time   `bun:"type:timestamptz,notnull" json:"valid_from"`
+	ValidTo   *time.Time  `bun:"type:timestamptz" json:"valid_to"`
+}
+
+type PriceChange struct {
+	ID        int         `bun:"type:int,pk" json:"id"`
+	ProductID int         `bun:"type:int,notnull" json:"product_id"`
+	Price     money.Money `bun:"type:decimal(10,2),notnull" json:"price"`
+	StartsAt  time.Time   `bun:"type:timestamptz,notnull" json:"starts_at"`
+	EndsAt    *time.Time  `bun:"type:timestamptz" json:"ends_at"`
+	Status    string      `bun:"type:text,notnull" json:"status"`
+}
+
+func GetPriceHistory(productID int) ([]PriceHistoryEntry, error) {
+	history := []PriceHistoryEntry{}
+	query := `SELECT price, valid_from, valid_to FROM price_history WHERE product_id = ? ORDER BY valid_from DESC, id DESC`
+
+	rows, err := db.Proxy.GetReplicaDB().Query(query, productID)
+	if err != nil {
+		log.Error("Error fetching price history: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry PriceHistoryEntry
+		if err := rows.Scan(&entry.Price, &entry.ValidFrom, &entry.ValidTo); err != nil {
+			log.Error("Error scanning price history: ", err)
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func GetPriceChanges(productID int) ([]PriceChange, error) {
+	changes := []PriceChange{}
+	query := `
+	SELECT id, product_id, price, starts_at, ends_at, status
+	FROM scheduled_price_changes
+	WHERE product_id = ?
+	ORDER BY starts_at DESC
+	`
+	rows, err := db.Proxy.GetReplicaDB().Query(query, productID)
+	if err != nil {
+		log.Error("Error fetching price changes: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change PriceChange
+		if err := rows.Scan(&change.ID, &change.ProductID, &change.Price, &change.StartsAt, &change.EndsAt, &change.Status); err != nil {
+			log.Error("Error scanning price change: ", err)
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// SchedulePriceChange plans a new price for a product. Changes of the same
+// product must not overlap, as each one restores the price it replaced.
+func SchedulePriceChange(change *PriceChange) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Блокируем товар, чтобы параллельные изменения цены не пересеклись
+	var productID int
+	err = tx.QueryRow(`SELECT id FROM products WHERE id = ? AND deleted_at IS NULL FOR UPDATE`, change.ProductID).Scan(&productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		log.Error("Error locking product: ", err)
+		return err
+	}
+
+	var overlaps bool
+	overlapQuery := `
+	SELECT EXISTS(
+		SELECT 1 FROM scheduled_price_changes
+		WHERE product_id = ? AND status IN (?, ?)
+			AND tstzrange(starts_at, ends_at) && tstzrange(?, ?)
+	)
+	`
+	err = tx.QueryRow(overlapQuery, change.ProductID, PriceChangeScheduled, PriceChangeActive, change.StartsAt, change.EndsAt).Scan(&overlaps)
+	if err != nil {
+		log.Error("Error checking price changes: ", err)
+		return err
+	}
+	if overlaps {
+		return ErrPriceChangeOverlaps
+	}
+
+	insertQuery := `
+	INSERT INTO scheduled_price_changes (product_id, price, starts_at, ends_at, status)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING id
+	`
+	change.Status = PriceChangeScheduled
+	err = tx.QueryRow(insertQuery, change.ProductID, change.Price, change.StartsAt, change.EndsAt, change.Status).Scan(&change.ID)
+	if err != nil {
+		log.Error("Error scheduling price change: ", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// CancelPriceChange cancels a change that has not started yet.
+func CancelPriceChange(id int) error {
+	query := `UPDATE scheduled_price_changes SET status = ? WHERE id = ? AND status = ?`
+	result, err := db.Proxy.GetPrimaryDB().Exec(query, PriceChangeCancelled, id, PriceChangeScheduled)
+	if err != nil {
+		log.Error("Error cancelling price change: ", err)
+		return err
+	}
+
+	return expectAffected(result, ErrPriceChangeNotFound)
+}
+
+// ApplyScheduledPriceChanges starts the changes whose time has come and
+// restores the previous price of those that have ended. Rows locked by
+// another instance of the job are skipped.
+func ApplyScheduledPriceChanges() error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Шаг 1: Применение наступивших изменений
+	startQuery := `
+	WITH due AS (
+		SELECT s.id, s.product_id, s.price, s.ends_at, p.price AS previous_price
+		FROM scheduled_price_changes s
+		JOIN products p ON p.id = s.product_id
+		WHERE s.status = ? AND s.starts_at <= NOW()
+		FOR UPDATE OF s, p SKIP LOCKED
+	), started AS (
+		UPDATE scheduled_price_changes s
+		SET previous_price = due.previous_price,
+			status = CASE WHEN due.ends_at IS NULL OR due.ends_at <= NOW() THEN ? ELSE ? END
+		FROM due
+		WHERE s.id = due.id
+	)
+	UPDATE products p SET price = due.price
+	FROM due
+	WHERE p.id = due.product_id AND (due.ends_at IS NULL OR due.ends_at > NOW())
+	`
+	if _, err = tx.Exec(startQuery, PriceChangeScheduled, PriceChangeCompleted, PriceChangeActive); err != nil {
+		log.Error("Error applying price changes: ", err)
+		return err
+	}
+
+	// Шаг 2: Возврат прежней цены по окончании изменения
+	endQuery := `
+	WITH ended AS (
+		UPDATE scheduled_price_changes s
+		SET status = ?
+		WHERE s.status = ? AND s.ends_at <= NOW()
+			AND s.id IN (
+				SELECT id FROM scheduled_price_changes
+				WHERE status = ? AND ends_at <= NOW()
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING s.product_id, s.previous_price
+	)
+	UPDATE products p SET price = ended.previous_price
+	FROM ended
+	WHERE p.id = ended.product_id
+	`
+	if _, err = tx.Exec(endQuery, PriceChangeCompleted, PriceChangeActive, PriceChangeActive); err != nil {
+		log.Error("Error ending price changes: ", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+const (
+	FacetType         = "type"
+	FacetManufacturer = "manufacturer"
+	FacetPrice        = "price"
+)
+
+var ErrInvalidFacet = errors.New("invalid facet")
+
+// priceBucketBounds splits prices into [0, 10), [10, 50), ..., [1000, ∞).
+// The bounds are in minor units.
+var priceBucketBounds = []int64{1000, 5000, 10000, 50000, 100000}
+
+var facetQueries = map[string]string{
+	FacetType:         `SELECT 'type', m.type_name, count(*) FROM matched m GROUP BY m.type_name`,
+	FacetManufacturer: `SELECT 'manufacturer', coalesce(m.manufacturer, ''), count(*) FROM matched m GROUP BY 2`,
+	FacetPrice:        `SELECT 'price', width_bucket(m.price, CAST(? AS numeric[]))::text, count(*) FROM matched m GROUP BY 2`,
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type PriceBucketCount struct {
+	Min   money.Money  `json:"min"`
+	Max   *money.Money `json:"max,omitempty"`
+	Count int          `json:"count"`
+}
+
+type ProductFacets struct {
+	Types         []FacetCount       `json:"type,omitempty"`
+	Manufacturers []FacetCount       `json:"manufacturer,omitempty"`
+	Prices        []PriceBucketCount `json:"price,omitempty"`
+}
+
+// GetProductFacets counts the products matching the filter per type,
+// manufacturer and price bucket. Only the requested facets are computed.
+// Pagination fields of the filter are ignored.
+func GetProductFacets(filter ProductFilter, facets []string) (ProductFacets, error) {
+	var result ProductFacets
+
+	q := newProductQuery(filter)
+	// Ранг и сниппет для подсчёта не нужны
+	q.columns, q.selectArgs = productColumns, nil
+	matched, args := q.build()
+
+	var parts []string
+	for _, facet := range facets {
+		part, ok := facetQueries[facet]
+		if !ok {
+			return result, ErrInvalidFacet
+		}
+		if facet == FacetPrice {
+			bounds := make([]string, len(priceBucketBounds))
+			for i, bound := range priceBucketBounds {
+				bounds[i] = money.New(bound, money.DefaultCurrency).String()
+			}
+			args = append(args, pgdialect.Array(bounds))
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return result, nil
+	}
+
+	query := "WITH matched AS (" + matched + ") " + strings.Join(parts, " UNION ALL ") + " ORDER BY 1, 3 DESC, 2"
+
+	rows, err := db.Proxy.GetReplicaDB().Query(query, args...)
+	if err != nil {
+		log.Error("Error counting product facets: ", err)
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet, value string
+		var count int
+		if err := rows.Scan(&facet, &value, &count); err != nil {
+			log.Error("Error scanning product facet: ", err)
+			return result, err
+		}
+		value = strings.TrimSpace(value)
+
+		switch facet {
+		case FacetType:
+			result.Types = append(result.Types, FacetCount{Value: value, Count: count})
+		case FacetManufacturer:
+			result.Manufacturers = append(result.Manufacturers, FacetCount{Value: value, Count: count})
+		case FacetPrice:
+			bucket, err := priceBucket(value)
+			if err != nil {
+				return result, err
+			}
+			bucket.Count = count
+			result.Prices = append(result.Prices, bucket)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return result, err
+	}
+	sort.Slice(result.Prices, func(i, j int) bool { return result.Prices[i].Min.Amount < result.Prices[j].Min.Amount })
+
+	return result, nil
+}
+
+// priceBucket converts a width_bucket index into the price range it covers.
+func priceBucket(index string) (PriceBucketCount, error) {
+	bucket := PriceBucketCount{Min: money.New(0, money.DefaultCurrency)}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i > len(priceBucketBounds) {
+		return bucket, fmt.Errorf("unexpected price bucket %q", index)
+	}
+	if i > 0 {
+		bucket.Min = money.New(priceBucketBounds[i-1], money.DefaultCurrency)
+	}
+	if i < len(priceBucketBounds) {
+		max := money.New(priceBucketBounds[i], money.DefaultCurrency)
+		bucket.Max = &max
+	}
+	return bucket, nil
+}
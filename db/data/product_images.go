@@ -0,0 +1,129 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/storage"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+var ErrImageNotFound = errors.New("image not found")
+
+type ProductImage struct {
+	ID           int    `bun:"type:int,pk" json:"id"`
+	ProductID    int    `bun:"type:int,notnull" json:"-"`
+	Key          string `bun:"type:text,notnull" json:"-"`
+	ThumbnailKey string `bun:"type:text,notnull" json:"-"`
+	ContentType  string `bun:"type:text,notnull" json:"content_type"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (image *ProductImage) setURLs() {
+	if storage.Default == nil {
+		return
+	}
+	image.URL = storage.Default.URL(image.Key)
+	image.ThumbnailURL = storage.Default.URL(image.ThumbnailKey)
+}
+
+func AddProductImage(image *ProductImage) error {
+	query := `
+	INSERT INTO product_images (product_id, key, thumbnail_key, content_type, position)
+	SELECT ?, ?, ?, ?, coalesce(max(position) + 1, 0) FROM product_images WHERE product_id = ?
+	RETURNING id
+	`
+	err := db.Proxy.GetPrimaryDB().QueryRow(query,
+		image.ProductID, image.Key, image.ThumbnailKey, image.ContentType, image.ProductID,
+	).Scan(&image.ID)
+	if hasPgErrorCode(err, pgForeignKeyViolation) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		log.Error("Error saving product image: ", err)
+		return err
+	}
+	image.setURLs()
+
+	return nil
+}
+
+// DeleteProductImage removes the image record and returns it, so that the
+// caller can delete the stored files.
+func DeleteProductImage(productID int, imageID int) (ProductImage, error) {
+	image := ProductImage{ID: imageID, ProductID: productID}
+	query := `DELETE FROM product_images WHERE id = ? AND product_id = ? RETURNING key, thumbnail_key, content_type`
+
+	err := db.Proxy.GetPrimaryDB().QueryRow(query, imageID, productID).Scan(&image.Key, &image.ThumbnailKey, &image.ContentType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return image, ErrImageNotFound
+	}
+	if err != nil {
+		log.Error("Error deleting product image: ", err)
+		return image, err
+	}
+
+	return image, nil
+}
+
+// getProductImages loads the images of all given products with a single query.
+func getProductImages(productIDs []int) (map[int][]ProductImage, error) {
+	images := make(map[int][]ProductImage)
+	if len(productIDs) == 0 {
+		return images, nil
+	}
+
+	query := `
+	SELECT id, product_id, key, thumbnail_key, content_type
+	FROM product_images
+	WHERE product_id IN (?)
+	ORDER BY product_id, position, id
+	`
+	rows, err := db.Proxy.GetCurrentDB().Query(query, bun.In(productIDs))
+	if err != nil {
+		log.Error("Error fetching product images: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var image ProductImage
+		if err := rows.Scan(&image.ID, &image.ProductID, &image.Key, &image.ThumbnailKey, &image.ContentType); err != nil {
+			log.Error("Error scanning product image: ", err)
+			return nil, err
+		}
+		image.setURLs()
+		images[image.ProductID] = append(images[image.ProductID], image)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// attachImages fills in the images of the products.
+func attachImages(products []Product) error {
+	ids := make([]int, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+
+	images, err := getProductImages(ids)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].Images = images[products[i].ID]
+		if products[i].Images == nil {
+			products[i].Images = []ProductImage{}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	DefaultProductPageSize = 20
+	MaxProductPageSize     = 100
+
+	// Private use characters delimit the matches in snippets, as they do not
+	// occur in product texts. They become <mark> tags once the snippet is escaped.
+	snippetStartSel = "\uE000"
+	snippetStopSel  = "\uE001"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+
+	ErrProductNotFound = errors.New("product not found")
+)
+
+// productSort describes the column a listing is ordered by and how the
+// keyset cursor value is compared against it.
+type productSort struct {
+	column string
+	cast   string
+	desc   bool
+}
+
+var productSorts = map[string]productSort{
+	"":           {column: "p.id"},
+	"price_asc":  {column: "p.price", cast: "numeric"},
+	"price_desc": {column: "p.price", cast: "numeric", desc: true},
+	"name_asc":   {column: "p.name", cast: "text"},
+	"name_desc":  {column: "p.name", cast: "text", desc: true},
+	"newest":     {column: "p.created_at", cast: "timestamptz", desc: true},
+	"relevance":  {column: "p.rank", cast: "real", desc: true},
+}
+
+// ProductFilter holds the listing options accepted by GET /products.
+type ProductFilter struct {
+	Name         string
+	TypeName     string
+	CategorySlug string
+	Manufacturer string
+	MinPrice     *money.Money
+	MaxPrice     *money.Money
+	Sort         string
+	Cursor       string
+	Limit        int
+}
+
+type ProductPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	HasMore    bool      `json:"has_more"`
+}
+
+// productQuery collects the WHERE conditions shared by every product listing,
+// so that list, search and detail queries select the same columns.
+type productQuery struct {
+	columns    string
+	selectArgs []interface{}
+	conditions []string
+	args       []interface{}
+}
+
+// Available stock excludes what is reserved in carts.
+const productColumns = `p.id, p.name, p.price, p.manufacturer, pt.name as type_name, p.created_at,
+	p.stock - ` + reservedTotal + ` AS available, p.rating_avg, p.rating_count`
+
+const productFrom = `
+	FROM products p
+	JOIN product_types pt ON p.product_type_id = pt.id
+	`
+
+func newProductQuery(filter ProductFilter) *productQuery {
+	q := &productQuery{columns: productColumns + ", 0::real AS rank, '' AS snippet"}
+	q.where("p.deleted_at IS NULL AND pt.deleted_at IS NULL")
+	if filter.Name != "" {
+		q.search(filter.Name)
+	}
+	if filter.TypeName != "" {
+		q.where(categorySubtree("name = ?"), filter.TypeName)
+	}
+	if filter.CategorySlug != "" {
+		q.where(categorySubtree("slug = ?"), filter.CategorySlug)
+	}
+	if filter.Manufacturer != "" {
+		q.where("p.manufacturer = ?", filter.Manufacturer)
+	}
+	if filter.MinPrice != nil {
+		q.where("p.price >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		q.where("p.price <= ?", *filter.MaxPrice)
+	}
+	return q
+}
+
+// search matches products by full-text search and falls back to trigram
+// similarity of the name, so that queries with typos still find something.
+// The % operator uses the trigram index on name::text and matches names with a
+// similarity above pg_trgm.similarity_threshold, 0.3 unless configured otherwise.
+// The rank and a highlighted snippet are selected along with the product.
+func (q *productQuery) search(text string) {
+	q.columns = productColumns + `,
+		GREATEST(ts_rank(p.search_vector, websearch_to_tsquery('simple', ?)), similarity(p.name::text, ?)) AS rank,
+		ts_headline('simple', p.name || ' ' || p.description, websearch_to_tsquery('simple', ?),
+			'StartSel=` + snippetStartSel + `, StopSel=` + snippetStopSel + `, MaxFragments=2, MinWords=5, MaxWords=20') AS snippet`
+	q.selectArgs = []interface{}{text, text, text}
+	q.where("(p.search_vector @@ websearch_to_tsquery('simple', ?) OR p.name::text % ?)", text, text)
+}
+
+// highlightSnippet escapes the snippet as HTML and marks the matches with <mark> tags.
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStartSel, "<mark>")
+	return strings.ReplaceAll(snippet, snippetStopSel, "</mark>")
+}
+
+func (q *productQuery) where(condition string, args ...interface{}) {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+}
+
+func (q *productQuery) whereClause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// build returns the SELECT statement and its arguments in placeholder order.
+func (q *productQuery) build() (string, []interface{}) {
+	query := "SELECT " + q.columns + productFrom + q.whereClause()
+	args := append(append([]interface{}{}, q.selectArgs...), q.args...)
+	return query, args
+}
+
+// productCursor points at the last product of a page. Value holds the sort
+// column of that product, ID breaks ties between equal values.
+type productCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func encodeProductCursor(sort string, product Product) string {
+	cursor := productCursor{Sort: sort, ID: product.ID}
+	switch productSorts[sort].column {
+	case "p.price":
+		cursor.Value = product.Price.String()
+	case "p.name":
+		cursor.Value = product.Name
+	case "p.created_at":
+		cursor.Value = product.CreatedAt.Format(time.RFC3339Nano)
+	case "p.rank":
+		cursor.Value = strconv.FormatFloat(float64(product.Rank), 'g', -1, 32)
+	}
+
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeProductCursor(sort string, encoded string) (productCursor, error) {
+	var cursor productCursor
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Sort != sort {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// ListProducts returns one page of the catalog using keyset pagination.
+// The next page is requested by passing NextCursor back with the same sort.
+func ListProducts(filter ProductFilter) (ProductPage, error) {
+	var page ProductPage
+
+	// Результаты поиска по умолчанию сортируются по релевантности
+	if filter.Sort == "" && filter.Name != "" {
+		filter.Sort = "relevance"
+	}
+	sort, ok := productSorts[filter.Sort]
+	if !ok {
+		return page, ErrInvalidSort
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultProductPageSize
+	}
+	if filter.Limit > MaxProductPageSize {
+		filter.Limit = MaxProductPageSize
+	}
+
+	query, args := newProductQuery(filter).build()
+	// Ранжирование и курсор применяются к результату поиска, поэтому оборачиваем его в подзапрос
+	query = "SELECT * FROM (" + query + ") p"
+
+	direction, comparison := "ASC", ">"
+	if sort.desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeProductCursor(filter.Sort, filter.Cursor)
+		if err != nil {
+			return page, err
+		}
+		if sort.cast == "" {
+			query += fmt.Sprintf(" WHERE p.id %s ?", comparison)
+			args = append(args, cursor.ID)
+		} else {
+			query += fmt.Sprintf(" WHERE (%s, p.id) %s (CAST(? AS %s), ?)", sort.column, comparison, sort.cast)
+			args = append(args, cursor.Value, cursor.ID)
+		}
+	}
+
+	if sort.cast == "" {
+		query += fmt.Sprintf(" ORDER BY p.id %s", direction)
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, p.id %s", sort.column, direction, direction)
+	}
+	// Запрашиваем на одну запись больше, чтобы узнать, есть ли следующая страница
+	query += " LIMIT ?"
+	args = append(args, filter.Limit+1)
+
+	rows, err := db.Proxy.GetCurrentDB().Query(query, args...)
+	if err != nil {
+		log.Error("Error listing products: ", err)
+		return page, err
+	}
+	defer rows.Close()
+
+	products, err := MapRowsToProducts(rows)
+	if err != nil {
+		return page, err
+	}
+
+	if len(products) > filter.Limit {
+		products = products[:filter.Limit]
+		page.HasMore = true
+		page.NextCursor = encodeProductCursor(filter.Sort, products[len(products)-1])
+	}
+	for i := range products {
+		products[i].Snippet = highlightSnippet(products[i].Snippet)
+	}
+	if err := attachImages(products); err != nil {
+		return page, err
+	}
+	page.Products = products
+
+	return page, nil
+}
+
+// GetProduct returns a single product together with its description and stock.
+func GetProduct(id int) (ProductDetail, error) {
+	var product ProductDetail
+
+	q := &productQuery{}
+	q.where("p.id = ? AND p.deleted_at IS NULL AND pt.deleted_at IS NULL", id)
+	query := "SELECT " + productColumns + ", coalesce(p.sku, ''), p.description, p.stock, p.weight_grams" + productFrom + q.whereClause()
+
+	err := db.Proxy.GetCurrentDB().QueryRow(query, q.args...).Scan(
+		&product.ID, &product.Name, &product.Price, &product.Manufacturer, &product.TypeName, &product.CreatedAt, &product.Available,
+		&product.Rating, &product.ReviewCount, &product.SKU, &product.Description, &product.Stock, &product.WeightGrams,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, ErrProductNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching product: ", err)
+		return product, err
+	}
+	product.trimSpace()
+
+	products := []Product{product.Product}
+	if err := attachImages(products); err != nil {
+		return product, err
+	}
+	product.Product = products[0]
+
+	return product, nil
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+func TestProductCursorRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	product := Product{
+		ID:        42,
+		Name:      "Desk lamp",
+		Price:     money.New(1999, "USD"),
+		CreatedAt: created,
+		Rank:      0.0759,
+	}
+
+	tests := []struct {
+		sort  string
+		value string
+	}{
+		{"", ""},
+		{"price_asc", "19.99"},
+		{"price_desc", "19.99"},
+		{"name_asc", "Desk lamp"},
+		{"newest", created.Format(time.RFC3339Nano)},
+		{"relevance", "0.0759"},
+	}
+
+	for _, test := range tests {
+		encoded := encodeProductCursor(test.sort, product)
+		cursor, err := decodeProductCursor(test.sort, encoded)
+		if err != nil {
+			t.Errorf("sort %q: decodeProductCursor returned %v", test.sort, err)
+			continue
+		}
+		if cursor.ID != product.ID || cursor.Value != test.value || cursor.Sort != test.sort {
+			t.Errorf("sort %q: cursor = %+v, want id %d and value %q", test.sort, cursor, product.ID, test.value)
+		}
+	}
+}
+
+func TestProductCursorRejectsOtherSort(t *testing.T) {
+	encoded := encodeProductCursor("price_asc", Product{ID: 1, Price: money.New(100, "USD")})
+	if _, err := decodeProductCursor("price_desc", encoded); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("decoding a cursor with another sort returned %v, want ErrInvalidCursor", err)
+	}
+
+	for _, encoded := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodeProductCursor("", encoded); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeProductCursor(%q) returned %v, want ErrInvalidCursor", encoded, err)
+		}
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	snippet := "<b>Desk</b> " + snippetStartSel + "lamp" + snippetStopSel + " & shade"
+	want := "&lt;b&gt;Desk&lt;/b&gt; <mark>lamp</mark> &amp; shade"
+	if got := highlightSnippet(snippet); got != want {
+		t.Errorf("highlightSnippet = %q, want %q", got, want)
+	}
+}
@@ -2,72 +2,62 @@ package data
 
 import (
 	"database/sql"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
 	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
 	"strings"
+	"time"
 )
 
 type Product struct {
-	ID           int     `bun:"type:int,pk" json:"id"`
-	Name         string  `bun:"type:char(128),notnull" json:"name"`
-	Price        float64 `bun:"type:decimal(10,2),notnull" json:"price"`
-	Manufacturer string  `bun:"type:char(64)" json:"manufacturer"`
-	TypeName     string  `json:"type_name"`
+	ID           int            `bun:"type:int,pk" json:"id"`
+	Name         string         `bun:"type:char(128),notnull" json:"name"`
+	Price        money.Money    `bun:"type:decimal(10,2),notnull" json:"price"`
+	Manufacturer string         `bun:"type:char(64)" json:"manufacturer"`
+	TypeName     string         `json:"type_name"`
+	CreatedAt    time.Time      `bun:"type:timestamptz,notnull" json:"created_at"`
+	Available    int            `json:"available"`
+	Rating       float64        `bun:"type:decimal(3,2),notnull" json:"rating"`
+	ReviewCount  int            `bun:"type:int,notnull" json:"review_count"`
+	Images       []ProductImage `json:"images"`
+	Rank         float32        `json:"rank,omitempty"`
+	Snippet      string         `json:"snippet,omitempty"`
+}
+
+type ProductDetail struct {
+	Product
+	SKU         string `bun:"type:text,unique" json:"sku"`
+	Description string `bun:"type:text,notnull" json:"description"`
+	Stock       int    `bun:"type:int,notnull" json:"stock"`
+	WeightGrams int    `bun:"type:int,notnull" json:"weight_grams"`
 }
 
 type CartItem struct {
-	ProductID int     `bun:"type:int,pk" json:"id"`
-	Product   string  `bun:"type:char(128),notnull" json:"product"`
-	Price     float64 `bun:"type:decimal(10,2),notnull" json:"price"`
-	Quantity  int     `bun:"type:int,notnull" json:"quantity"`
+	ProductID int         `bun:"type:int,pk" json:"id"`
+	Product   string      `bun:"type:char(128),notnull" json:"product"`
+	Price     money.Money `bun:"type:decimal(10,2),notnull" json:"price"`
+	Quantity  int         `bun:"type:int,notnull" json:"quantity"`
 }
 
 type Order struct {
-	ID              int     `bun:"type:int,pk" json:"id"`
-	DeliveryAddress string  `bun:"type:char(256),notnull" json:"delivery_address"`
-	OrderDate       string  `bun:"type:timestamp,notnull" json:"order_date"`
-	TotalPrice      float64 `bun:"type:decimal(10,2),notnull" json:"total_price"`
+	ID              int         `bun:"type:int,pk" json:"id"`
+	DeliveryAddress string      `bun:"type:char(256),notnull" json:"delivery_address"`
+	Address         *Address    `json:"address,omitempty"`
+	OrderDate       string      `bun:"type:timestamp,notnull" json:"order_date"`
+	Status          string      `bun:"type:text,notnull" json:"status"`
+	TotalPrice      money.Money `bun:"type:decimal(10,2),notnull" json:"total_price"`
+	ExchangeRate    money.Rate  `json:"exchange_rate"`
+	Region          string      `json:"region"`
+	Subtotal        money.Money `json:"subtotal"`
+	Discount        money.Money `json:"discount"`
+	Shipping        money.Money `json:"shipping"`
+	Tax             money.Money `json:"tax"`
 	CartItems       []CartItem
 }
 
-func GetAllProducts() ([]Product, error) {
-	var products []Product
-
-	query := "SELECT p.id, p.name, p.price, p.manufacturer, pt.name as type_name FROM products p JOIN product_types pt ON p.product_type_id = pt.id"
-
-	rows, err := db.Proxy.GetCurrentDB().Query(query)
-	if err != nil {
-		log.Error("Error fetching all products: ", err)
-		return nil, err
-	}
-	defer rows.Close()
-	products, err = MapRowsToProducts(rows)
-	if err != nil {
-		return nil, err
-	}
-
-	return products, nil
-}
-
-func SearchProductByName(name string) ([]Product, error) {
-	var products []Product
-	query := "SELECT id, name, price, manufacturer, product_type_id FROM products WHERE name ILIKE ?"
-
-	rows, err := db.Proxy.GetCurrentDB().Query(query, "%"+name+"%")
-	if err != nil {
-		log.Error("Error searching product by name: ", err)
-		return nil, err
-	}
-	defer rows.Close()
-	products, err = MapRowsToProducts(rows)
-	if err != nil {
-		return nil, err
-	}
-
-	return products, nil
-}
-
 func GetCartItems(userID string) ([]CartItem, error) {
 	var cartItems []CartItem
 
@@ -94,26 +84,19 @@ func GetCartItems(userID string) ([]CartItem, error) {
 	return cartItems, nil
 }
 
-func AddProductToCart(userID string, productID int, quantity int) error {
-	tx, err := db.Proxy.GetCurrentDB().Begin()
+// AddProductToCart increments the quantity of a product in the user's cart.
+// With reserve set, the whole cart quantity of the product is held for ReservationTTL.
+func AddProductToCart(userID string, productID int, quantity int, reserve bool) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
 	if err != nil {
 		log.Error("Error starting transaction: ", err)
 		return err
 	}
 	defer tx.Rollback()
 
-	var cartID int
-	// Попытка найти существующую корзину для пользователя
-	cartQuery := `SELECT id FROM cart WHERE user_id = ?`
-	err = tx.QueryRow(cartQuery, userID).Scan(&cartID)
+	cartID, err := getOrCreateCart(tx, userID)
 	if err != nil {
-		// Если корзина не найдена, создаем новую
-		insertCartQuery := `INSERT INTO cart (user_id) VALUES (?) RETURNING id`
-		err = tx.QueryRow(insertCartQuery, userID).Scan(&cartID)
-		if err != nil {
-			log.Error("Error creating a new cart: ", err)
-			return err
-		}
+		return err
 	}
 
 	// Попытка добавить товар в корзину или обновить его количество, если он уже там есть
@@ -125,11 +108,21 @@ func AddProductToCart(userID string, productID int, quantity int) error {
 	`
 
 	_, err = tx.Exec(updateQuery, cartID, productID, quantity)
+	if hasPgErrorCode(err, pgForeignKeyViolation) {
+		return ErrProductNotFound
+	}
 	if err != nil {
 		log.Error("Error adding/updating product in cart: ", err)
 		return err
 	}
 
+	// Резервирование товара на складе
+	if reserve {
+		if err = reserveCartItem(tx, userID, cartID, productID); err != nil {
+			return err
+		}
+	}
+
 	// Если дошли до сюда без ошибок, подтверждаем транзакцию
 	if err = tx.Commit(); err != nil {
 		log.Error("Error committing transaction: ", err)
@@ -140,7 +133,7 @@ func AddProductToCart(userID string, productID int, quantity int) error {
 }
 
 func RemoveProductFromCart(userID string, productID int) error {
-	tx, err := db.Proxy.GetCurrentDB().Begin()
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
 	if err != nil {
 		log.Error("Error starting transaction: ", err)
 		return err
@@ -165,13 +158,18 @@ func RemoveProductFromCart(userID string, productID int) error {
 	}
 
 	// Удаляем товар из корзины, если его количество стало равно 0
-	deleteQuery := `DELETE FROM cart_items WHERE quantity = 0`
+	deleteQuery := `DELETE FROM cart_items WHERE cart_id = ? AND product_id = ? AND quantity <= 0`
 	_, err = tx.Exec(deleteQuery, cartID, productID)
 	if err != nil {
 		log.Error("Error deleting product from cart: ", err)
 		return err
 	}
 
+	// Резерв не может превышать количество товара в корзине
+	if err = syncReservations(tx, userID); err != nil {
+		return err
+	}
+
 	// Если дошли до сюда без ошибок, подтверждаем транзакцию
 	if err = tx.Commit(); err != nil {
 		log.Error("Error committing transaction: ", err)
@@ -181,95 +179,169 @@ func RemoveProductFromCart(userID string, productID int) error {
 	return nil
 }
 
-func GetOrders(userID string) ([]Order, error) {
-	var orders []Order
-	query := `
-	SELECT o.id, o.delivery_address, o.order_date 
-	FROM orders o
-	WHERE user_id = ?
-    `
-	rows, err := db.Proxy.GetCurrentDB().Query(query, userID)
+// SetCartItemQuantity sets the exact quantity of a product in the user's cart.
+// A zero quantity removes the product. With reserve set, the new quantity is held for ReservationTTL.
+func SetCartItemQuantity(userID string, productID int, quantity int, reserve bool) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
 	if err != nil {
-		log.Error("Error fetching orders: ", err)
-		return nil, err
+		log.Error("Error starting transaction: ", err)
+		return err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var order Order
-		err := rows.Scan(&order.ID, &order.DeliveryAddress, &order.OrderDate)
+	defer tx.Rollback()
 
-		order.DeliveryAddress = strings.TrimSpace(order.DeliveryAddress)
+	cartID, err := getOrCreateCart(tx, userID)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			log.Error("Error scanning order: ", err)
-			return nil, err
+	if quantity == 0 {
+		deleteQuery := `DELETE FROM cart_items WHERE cart_id = ? AND product_id = ?`
+		if _, err = tx.Exec(deleteQuery, cartID, productID); err != nil {
+			log.Error("Error deleting product from cart: ", err)
+			return err
+		}
+	} else {
+		updateQuery := `
+        INSERT INTO cart_items (cart_id, product_id, quantity)
+        VALUES (?, ?, ?)
+        ON CONFLICT (cart_id, product_id)
+        DO UPDATE SET quantity = EXCLUDED.quantity
+		`
+		_, err = tx.Exec(updateQuery, cartID, productID, quantity)
+		if hasPgErrorCode(err, pgForeignKeyViolation) {
+			return ErrProductNotFound
 		}
-
-		order.CartItems, err = GetOrderItems(order.ID)
 		if err != nil {
-			return nil, err
+			log.Error("Error setting product quantity in cart: ", err)
+			return err
 		}
+	}
 
-		totalPrice := 0.0
-		for _, item := range order.CartItems {
-			totalPrice += item.Price * float64(item.Quantity)
+	// Резерв не может превышать количество товара в корзине
+	if err = syncReservations(tx, userID); err != nil {
+		return err
+	}
+	if reserve && quantity > 0 {
+		if err = reserveCartItem(tx, userID, cartID, productID); err != nil {
+			return err
 		}
-		order.TotalPrice = totalPrice
-
-		orders = append(orders, order)
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Error("Error iterating rows: ", err)
-		return nil, err
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
 	}
 
-	return orders, nil
+	return nil
 }
 
-func GetOrderItems(orderID int) ([]CartItem, error) {
-	var cartItems []CartItem
-	query := `
-	SELECT p.id, p.name, p.price, oi.quantity
-	FROM order_items oi
-	JOIN products p ON oi.product_id = p.id
-	WHERE oi.order_id = ?
-    `
-	rows, err := db.Proxy.GetCurrentDB().Query(query, orderID)
+// ClearCart removes every item from the user's cart and releases its reservations.
+func ClearCart(userID string) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
 	if err != nil {
-		log.Error("Error fetching order items: ", err)
-		return nil, err
+		log.Error("Error starting transaction: ", err)
+		return err
 	}
-	defer rows.Close()
-	cartItems, err = MapRowsToCartItems(rows)
-	if err != nil {
-		return nil, err
+	defer tx.Rollback()
+
+	deleteQuery := `DELETE FROM cart_items WHERE cart_id IN (SELECT id FROM cart WHERE user_id = ?)`
+	if _, err = tx.Exec(deleteQuery, userID); err != nil {
+		log.Error("Error clearing cart: ", err)
+		return err
 	}
 
-	return cartItems, nil
+	if err = syncReservations(tx, userID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
 }
 
-func PlaceOrder(userID string, deliveryAddress string) error {
+// getOrCreateCart returns the id of the user's cart, creating the cart if there is none.
+func getOrCreateCart(tx bun.Tx, userID string) (int, error) {
+	var cartID int
+	// Попытка найти существующую корзину для пользователя
+	cartQuery := `SELECT id FROM cart WHERE user_id = ?`
+	err := tx.QueryRow(cartQuery, userID).Scan(&cartID)
+	if err != nil {
+		// Если корзина не найдена, создаем новую
+		insertCartQuery := `INSERT INTO cart (user_id) VALUES (?) RETURNING id`
+		err = tx.QueryRow(insertCartQuery, userID).Scan(&cartID)
+		if err != nil {
+			log.Error("Error creating a new cart: ", err)
+			return 0, err
+		}
+	}
+	return cartID, nil
+}
+
+// PlaceOrder turns the user's cart into an order, recording the currency and
+// rate the customer saw the prices in and the price breakdown for the region.
+// The order is delivered to the saved address with the id, or, if addressID is 0
+// and no free-form delivery address is given, to the user's default address.
+// A saved address is copied into the order and its country is the default region.
+// It returns the pending payment of the order, to be authorized with the payment provider.
+func PlaceOrder(userID string, deliveryAddress string, addressID int, region string, rate money.Rate) (Payment, error) {
 	// Начало транзакции
-	tx, err := db.Proxy.GetCurrentDB().Begin()
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
 	if err != nil {
 		log.Error("Error starting transaction: ", err)
-		return err
+		return Payment{}, err
+	}
+
+	// Адрес доставки: выбранный сохранённый адрес или адрес по умолчанию
+	var address *Address
+	var savedAddressID *int
+	var shippingAddress *string
+	if addressID != 0 || strings.TrimSpace(deliveryAddress) == "" {
+		if address, err = orderAddress(tx, userID, addressID); err != nil {
+			tx.Rollback()
+			return Payment{}, err
+		}
+	}
+	if address != nil {
+		deliveryAddress = address.String()
+		if runes := []rune(deliveryAddress); len(runes) > 256 {
+			deliveryAddress = string(runes[:256])
+		}
+		// Регион налогообложения определяется страной адреса доставки
+		if region == "" {
+			region = address.Country
+		} else if strings.SplitN(region, "-", 2)[0] != address.Country {
+			tx.Rollback()
+			return Payment{}, ErrRegionMismatch
+		}
+		snapshot, err := json.Marshal(address)
+		if err != nil {
+			tx.Rollback()
+			return Payment{}, err
+		}
+		savedAddressID, shippingAddress = &address.ID, new(string)
+		*shippingAddress = string(snapshot)
 	}
 
 	// Шаг 1: Создание заказа
 	var orderID int
 	orderQuery := `
-        INSERT INTO orders (user_id, delivery_address, order_date)
-        VALUES (?, ?, NOW())
+        INSERT INTO orders (user_id, delivery_address, address_id, shipping_address, order_date, currency, exchange_rate, status)
+        VALUES (?, ?, ?, CAST(? AS jsonb), NOW(), ?, CAST(? AS numeric), ?)
         RETURNING id
     `
-	err = tx.QueryRow(orderQuery, userID, deliveryAddress, userID).Scan(&orderID)
+	err = tx.QueryRow(orderQuery, userID, deliveryAddress, savedAddressID, shippingAddress, rate.Currency, rate.String(),
+		OrderStatusPending).Scan(&orderID)
 	if err != nil {
 		tx.Rollback()
 		log.Error("Error creating order: ", err)
-		return err
+		return Payment{}, err
+	}
+	if err = recordOrderEvent(tx, orderID, nil, OrderStatusPending, OrderActorCustomer, ""); err != nil {
+		tx.Rollback()
+		return Payment{}, err
 	}
 
 	// Проверка, что корзина не пуста
@@ -279,7 +351,13 @@ func PlaceOrder(userID string, deliveryAddress string) error {
 	if err != nil || cartItemCount == 0 {
 		tx.Rollback()
 		log.Error("Error checking cart items: ", err)
-		return fmt.Errorf("cart is empty")
+		return Payment{}, ErrCartEmpty
+	}
+
+	// Списание товаров со склада
+	if err = takeStockForCart(tx, userID); err != nil {
+		tx.Rollback()
+		return Payment{}, err
 	}
 
 	// Шаг 2: Копирование содержимого корзины в заказ
@@ -295,7 +373,57 @@ func PlaceOrder(userID string, deliveryAddress string) error {
 	if err != nil {
 		tx.Rollback()
 		log.Error("Error copying cart items to order items: ", err)
-		return err
+		return Payment{}, err
+	}
+
+	// Применение купона корзины и учёт его использования
+	discount, err := redeemCartCoupon(tx, userID, orderID)
+	if err != nil {
+		tx.Rollback()
+		return Payment{}, err
+	}
+
+	// Расчёт доставки и налога, итог сохраняется в заказе
+	quote, err := quoteCart(tx, userID, region, discount)
+	if err != nil {
+		tx.Rollback()
+		return Payment{}, err
+	}
+	breakdownQuery := `UPDATE orders SET region = NULLIF(?, ''), subtotal = ?, shipping = ?, tax = ?, total = ? WHERE id = ?`
+	_, err = tx.Exec(breakdownQuery, quote.Region, quote.Subtotal, quote.Shipping, quote.Tax, quote.Total, orderID)
+	if err != nil {
+		tx.Rollback()
+		log.Error("Error saving order totals: ", err)
+		return Payment{}, err
+	}
+
+	// Платёж на итоговую сумму ожидает авторизации у платёжного провайдера
+	payment, err := createPayment(tx, orderID, quote.Total)
+	if err != nil {
+		tx.Rollback()
+		return Payment{}, err
+	}
+
+	// Событие о новом заказе для внешних систем
+	placed := OrderPlaced{
+		OrderID:         orderID,
+		UserID:          userID,
+		Status:          OrderStatusPending,
+		DeliveryAddress: deliveryAddress,
+		Address:         address,
+		Region:          quote.Region,
+		Currency:        rate.Currency,
+		ExchangeRate:    rate.String(),
+		Subtotal:        quote.Subtotal,
+		Discount:        quote.Discount,
+		Shipping:        quote.Shipping,
+		Tax:             quote.Tax,
+		Total:           quote.Total,
+		PaymentID:       payment.ID,
+	}
+	if err = enqueueOrderPlaced(tx, placed); err != nil {
+		tx.Rollback()
+		return Payment{}, err
 	}
 
 	// Шаг 3: Очистка корзины
@@ -309,67 +437,64 @@ func PlaceOrder(userID string, deliveryAddress string) error {
 	if err != nil {
 		tx.Rollback()
 		log.Error("Error clearing cart: ", err)
-		return err
+		return Payment{}, err
+	}
+
+	// Товары списаны со склада, резерв больше не нужен
+	if err = syncReservations(tx, userID); err != nil {
+		tx.Rollback()
+		return Payment{}, err
 	}
 
 	// Завершение транзакции
 	err = tx.Commit()
 	if err != nil {
 		log.Error("Error committing transaction: ", err)
-		return err
+		return Payment{}, err
 	}
 
-	return nil
+	return payment, nil
 }
 
+// CancelOrder cancels a pending or paid order of the user. The order and its items
+// are kept with the cancelled status; stock and the coupon use are returned.
 func CancelOrder(userID string, orderID int) error {
-	tx, err := db.Proxy.GetCurrentDB().Begin()
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
 	if err != nil {
 		log.Error("Error starting transaction: ", err)
 		return err
 	}
+	defer tx.Rollback()
+
 	// Шаг 0: Проверка, что заказ принадлежит пользователю
-	var ownerID string
-	ownerQuery := `SELECT user_id FROM orders WHERE id = ?`
-	err = tx.QueryRow(ownerQuery, orderID).Scan(&ownerID)
-	if ownerID == "" {
-		tx.Rollback()
-		return fmt.Errorf("Order not found")
+	var status string
+	ownerQuery := `SELECT status FROM orders WHERE id = ? AND user_id = ? FOR UPDATE`
+	err = tx.QueryRow(ownerQuery, orderID, userID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOrderNotFound
 	}
-
 	if err != nil {
-		tx.Rollback()
-		log.Error("Error fetching order owner: ", err)
+		log.Error("Error fetching order: ", err)
 		return err
 	}
 
-	if ownerID != userID {
-		tx.Rollback()
-		log.Error("Order does not belong to the user")
-		return err
+	// Покупатель может отменить только неотправленный заказ
+	if status != OrderStatusPending && status != OrderStatusPaid {
+		return ErrOrderNotCancellable
 	}
 
-	// Шаг 1: Удаление содержимого заказа
-	deleteOrderItemsQuery := `DELETE FROM order_items WHERE order_id = ?`
-	_, err = tx.Exec(deleteOrderItemsQuery, orderID)
-	if err != nil {
-		tx.Rollback()
-		log.Error("Error deleting order items: ", err)
+	// Шаг 1: Смена статуса с записью события
+	if err = transitionOrder(tx, orderID, status, OrderStatusCancelled, OrderActorCustomer, ""); err != nil {
 		return err
 	}
 
-	// Шаг 2: Удаление заказа
-	deleteOrderQuery := `DELETE FROM orders WHERE id = ? AND user_id = ?`
-	_, err = tx.Exec(deleteOrderQuery, orderID, userID)
-	if err != nil {
-		tx.Rollback()
-		log.Error("Error deleting order: ", err)
+	// Шаг 2: Возврат товаров на склад и использования купона
+	if err = releaseOrder(tx, orderID, status, OrderStatusCancelled); err != nil {
 		return err
 	}
 
 	// Завершение транзакции
-	err = tx.Commit()
-	if err != nil {
+	if err = tx.Commit(); err != nil {
 		log.Error("Error committing transaction: ", err)
 		return err
 	}
@@ -377,15 +502,26 @@ func CancelOrder(userID string, orderID int) error {
 	return nil
 }
 
+// CartTotal sums the price of every item times its quantity. The items must be
+// priced in currency, which is also the currency of the total of an empty cart.
+func CartTotal(items []CartItem, currency string) (money.Money, error) {
+	total := money.New(0, currency)
+	for _, item := range items {
+		var err error
+		if total, err = total.Add(item.Price.Mul(item.Quantity)); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
 func MapRowsToProducts(rows *sql.Rows) ([]Product, error) {
 	var products []Product
 	for rows.Next() {
 		var product Product
-		err := rows.Scan(&product.ID, &product.Name, &product.Price, &product.Manufacturer, &product.TypeName)
+		err := rows.Scan(&product.ID, &product.Name, &product.Price, &product.Manufacturer, &product.TypeName, &product.CreatedAt, &product.Available, &product.Rating, &product.ReviewCount, &product.Rank, &product.Snippet)
 
-		product.Name = strings.TrimSpace(product.Name)
-		product.Manufacturer = strings.TrimSpace(product.Manufacturer)
-		product.TypeName = strings.TrimSpace(product.TypeName)
+		product.trimSpace()
 
 		if err != nil {
 			log.Error("Error scanning product: ", err)
@@ -424,3 +560,10 @@ func MapRowsToCartItems(rows *sql.Rows) ([]CartItem, error) {
 
 	return cartItems, nil
 }
+
+// trimSpace strips the padding Postgres adds to char(n) columns.
+func (product *Product) trimSpace() {
+	product.Name = strings.TrimSpace(product.Name)
+	product.Manufacturer = strings.TrimSpace(product.Manufacturer)
+	product.TypeName = strings.TrimSpace(product.TypeName)
+}
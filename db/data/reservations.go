@@ -0,0 +1,108 @@
+package data
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// ReservationTTL is how long stock stays reserved for an item added to a cart.
+var ReservationTTL = 15 * time.Minute
+
+// reservedByOthers sums active reservations of a product held by other users.
+const reservedByOthers = `coalesce((
+	SELECT sum(r.quantity) FROM stock_reservations r
+	WHERE r.product_id = p.id AND r.user_id <> ? AND r.expires_at > NOW()
+), 0)`
+
+// reservedTotal sums all active reservations of a product.
+const reservedTotal = `coalesce((
+	SELECT sum(r.quantity) FROM stock_reservations r
+	WHERE r.product_id = p.id AND r.expires_at > NOW()
+), 0)`
+
+// reserveCartItem reserves the whole cart quantity of a product for the user,
+// failing if other users' reservations leave too little stock.
+func reserveCartItem(tx bun.Tx, userID string, cartID int, productID int) error {
+	var item InsufficientStockItem
+	query := `
+	SELECT p.id, p.name, p.stock - ` + reservedByOthers + `, ci.quantity
+	FROM products p
+	JOIN cart_items ci ON ci.product_id = p.id AND ci.cart_id = ?
+	WHERE p.id = ?
+	FOR UPDATE OF p
+	`
+	err := tx.QueryRow(query, userID, cartID, productID).Scan(&item.ProductID, &item.Name, &item.Available, &item.Requested)
+	if err != nil {
+		log.Error("Error checking product availability: ", err)
+		return err
+	}
+
+	if item.Available < item.Requested {
+		item.Name = strings.TrimSpace(item.Name)
+		return &InsufficientStockError{Items: []InsufficientStockItem{item}}
+	}
+
+	reserveQuery := `
+	INSERT INTO stock_reservations (user_id, product_id, quantity, expires_at)
+	VALUES (?, ?, ?, NOW() + CAST(? AS interval))
+	ON CONFLICT (user_id, product_id)
+	DO UPDATE SET quantity = EXCLUDED.quantity, expires_at = EXCLUDED.expires_at
+	`
+	_, err = tx.Exec(reserveQuery, userID, productID, item.Requested, ReservationTTL.String())
+	if err != nil {
+		log.Error("Error reserving stock: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// syncReservations shrinks the user's reservations to what is left in the cart.
+func syncReservations(tx bun.Tx, userID string) error {
+	updateQuery := `
+	UPDATE stock_reservations r
+	SET quantity = ci.quantity
+	FROM cart_items ci
+	JOIN cart c ON ci.cart_id = c.id
+	WHERE c.user_id = r.user_id AND ci.product_id = r.product_id
+		AND r.user_id = ? AND ci.quantity < r.quantity
+	`
+	if _, err := tx.Exec(updateQuery, userID); err != nil {
+		log.Error("Error updating reservations: ", err)
+		return err
+	}
+
+	deleteQuery := `
+	DELETE FROM stock_reservations r
+	WHERE r.user_id = ? AND NOT EXISTS (
+		SELECT 1 FROM cart_items ci
+		JOIN cart c ON ci.cart_id = c.id
+		WHERE c.user_id = r.user_id AND ci.product_id = r.product_id AND ci.quantity > 0
+	)
+	`
+	if _, err := tx.Exec(deleteQuery, userID); err != nil {
+		log.Error("Error releasing reservations: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseExpiredReservations deletes reservations whose TTL has passed.
+func ReleaseExpiredReservations() error {
+	result, err := db.Proxy.GetPrimaryDB().Exec(`DELETE FROM stock_reservations WHERE expires_at <= NOW()`)
+	if err != nil {
+		log.Error("Error releasing expired reservations: ", err)
+		return err
+	}
+
+	if released, _ := result.RowsAffected(); released > 0 {
+		log.Infof("Released %d expired stock reservations", released)
+	}
+
+	return nil
+}
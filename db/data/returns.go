@@ -0,0 +1,566 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/google/uuid"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// Return statuses. An approved return waits for its refund to go through.
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnRefunded  = "refunded"
+)
+
+var (
+	ErrReturnNotFound      = errors.New("return not found")
+	ErrReturnNotAllowed    = errors.New("only delivered orders can be returned")
+	ErrInvalidReturnItems  = errors.New("invalid return items")
+	ErrReturnResolved      = errors.New("return is already resolved")
+	ErrReturnNotRefundable = errors.New("order has no captured payment to refund")
+)
+
+type ReturnItem struct {
+	ProductID int    `json:"product_id"`
+	Product   string `json:"product,omitempty"`
+	Quantity  int    `json:"quantity"`
+}
+
+type Return struct {
+	ID           int          `json:"id"`
+	OrderID      int          `json:"order_id"`
+	UserID       string       `json:"user_id"`
+	Status       string       `json:"status"`
+	Reason       string       `json:"reason"`
+	RefundAmount *money.Money `json:"refund_amount"`
+	Items        []ReturnItem `json:"items"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+type ReturnEvent struct {
+	ID         int       `json:"id"`
+	FromStatus *string   `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	ActorID    *string   `json:"actor_id,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const returnColumns = `r.id, r.order_id, r.user_id, r.status, r.reason, r.refund_amount, r.created_at, r.updated_at`
+
+// CreateReturn requests the return of lines of a delivered order of the user.
+// A line cannot be returned more times than it was ordered, counting every
+// return of the order that was not rejected.
+func CreateReturn(userID string, orderID int, reason string, items []ReturnItem) (Return, error) {
+	if len(items) == 0 {
+		return Return{}, ErrInvalidReturnItems
+	}
+	seen := make(map[int]bool, len(items))
+	for _, item := range items {
+		if item.Quantity <= 0 || seen[item.ProductID] {
+			return Return{}, ErrInvalidReturnItems
+		}
+		seen[item.ProductID] = true
+	}
+
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return Return{}, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка заказа, чтобы параллельные заявки не превысили заказанное количество
+	var status string
+	err = tx.QueryRow(`SELECT status FROM orders WHERE id = ? AND user_id = ? FOR UPDATE`, orderID, userID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Return{}, ErrOrderNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching order: ", err)
+		return Return{}, err
+	}
+	if status != OrderStatusDelivered {
+		return Return{}, ErrReturnNotAllowed
+	}
+
+	returnable, err := returnableQuantities(tx, orderID)
+	if err != nil {
+		return Return{}, err
+	}
+	for _, item := range items {
+		if item.Quantity > returnable[item.ProductID] {
+			return Return{}, fmt.Errorf("%w: product %d", ErrInvalidReturnItems, item.ProductID)
+		}
+	}
+
+	ret := Return{OrderID: orderID, UserID: userID, Status: ReturnRequested, Reason: reason, Items: items}
+	query := `INSERT INTO returns (order_id, user_id, status, reason) VALUES (?, ?, ?, ?) RETURNING id, created_at, updated_at`
+	if err = tx.QueryRow(query, orderID, userID, ret.Status, reason).Scan(&ret.ID, &ret.CreatedAt, &ret.UpdatedAt); err != nil {
+		log.Error("Error creating return: ", err)
+		return Return{}, err
+	}
+
+	for _, item := range items {
+		if _, err = tx.Exec(`INSERT INTO return_items (return_id, product_id, quantity) VALUES (?, ?, ?)`, ret.ID, item.ProductID, item.Quantity); err != nil {
+			log.Error("Error saving return item: ", err)
+			return Return{}, err
+		}
+	}
+
+	if err = recordReturnEvent(tx, ret.ID, nil, ReturnRequested, OrderActorCustomer, &userID, ""); err != nil {
+		return Return{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return Return{}, err
+	}
+
+	return ret, nil
+}
+
+// GetUserReturns lists the returns of the user, newest first.
+func GetUserReturns(userID string) ([]Return, error) {
+	return queryReturns(`r.user_id = ?`, userID)
+}
+
+// GetUserReturn returns a return of the user.
+func GetUserReturn(userID string, returnID int) (Return, error) {
+	returns, err := queryReturns(`r.id = ? AND r.user_id = ?`, returnID, userID)
+	if err != nil {
+		return Return{}, err
+	}
+	if len(returns) == 0 {
+		return Return{}, ErrReturnNotFound
+	}
+	return returns[0], nil
+}
+
+// GetReturns lists all returns, newest first, optionally only those in the status.
+func GetReturns(status string) ([]Return, error) {
+	if status == "" {
+		return queryReturns(`TRUE`)
+	}
+	return queryReturns(`r.status = ?`, status)
+}
+
+func GetReturn(returnID int) (Return, error) {
+	returns, err := queryReturns(`r.id = ?`, returnID)
+	if err != nil {
+		return Return{}, err
+	}
+	if len(returns) == 0 {
+		return Return{}, ErrReturnNotFound
+	}
+	return returns[0], nil
+}
+
+func GetReturnEvents(returnID int) ([]ReturnEvent, error) {
+	events := []ReturnEvent{}
+	query := `
+	SELECT id, from_status, to_status, actor, actor_id, note, created_at
+	FROM return_events
+	WHERE return_id = ?
+	ORDER BY id
+	`
+	rows, err := db.Proxy.GetCurrentDB().Query(query, returnID)
+	if err != nil {
+		log.Error("Error fetching return events: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event ReturnEvent
+		if err := rows.Scan(&event.ID, &event.FromStatus, &event.ToStatus, &event.Actor, &event.ActorID, &event.Note, &event.CreatedAt); err != nil {
+			log.Error("Error scanning return event: ", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// ApproveReturn approves a requested return, puts the returned items back in
+// stock and stores the pending refund of the return. Returning every remaining
+// line of the order refunds what is left of the payment, shipping included;
+// otherwise the lines are refunded at the price paid, with their share of the tax.
+// Orders without a captured payment cannot be refunded, so their returns are not approved.
+// Approving an approved return again returns it with the same refund, so a
+// failed refund can be retried without refunding twice.
+func ApproveReturn(returnID int, adminID string, note string) (Return, PaymentRefund, error) {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return Return{}, PaymentRefund{}, err
+	}
+	defer tx.Rollback()
+
+	ret, err := lockReturn(tx, returnID)
+	if err != nil {
+		return Return{}, PaymentRefund{}, err
+	}
+	if ret.Status != ReturnRequested && ret.Status != ReturnApproved {
+		return Return{}, PaymentRefund{}, ErrReturnResolved
+	}
+
+	// Блокировка заказа, чтобы одновременные одобрения видели суммы друг друга
+	if _, err = lockOrderStatus(tx, ret.OrderID); err != nil {
+		return Return{}, PaymentRefund{}, err
+	}
+
+	var amount *money.Money
+	if ret.Status == ReturnRequested {
+		if amount, err = returnRefundAmount(tx, ret); err != nil {
+			return Return{}, PaymentRefund{}, err
+		}
+	}
+
+	// Возврат денег сохраняется до обращения к провайдеру, ключ — номер заявки
+	refund, err := startPaymentRefund(tx, ret.OrderID, returnRefundKey(returnID), amount, &returnID)
+	if errors.Is(err, ErrPaymentNotFound) || errors.Is(err, ErrPaymentNotCaptured) {
+		return Return{}, PaymentRefund{}, ErrReturnNotRefundable
+	}
+	if err != nil {
+		return Return{}, PaymentRefund{}, err
+	}
+
+	if ret.Status == ReturnRequested {
+		// Возврат товаров на склад
+		restockQuery := `
+		UPDATE products p
+		SET stock = p.stock + ri.quantity
+		FROM return_items ri
+		WHERE ri.product_id = p.id AND ri.return_id = ?
+		`
+		if _, err = tx.Exec(restockQuery, returnID); err != nil {
+			log.Error("Error restocking returned items: ", err)
+			return Return{}, PaymentRefund{}, err
+		}
+
+		if err = updateReturnStatus(tx, returnID, ret.Status, ReturnApproved, &refund.Amount, OrderActorAdmin, &adminID, note); err != nil {
+			return Return{}, PaymentRefund{}, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return Return{}, PaymentRefund{}, err
+	}
+
+	ret, err = GetReturn(returnID)
+	return ret, refund, err
+}
+
+// RejectReturn rejects a requested return. Its lines can be requested again.
+func RejectReturn(returnID int, adminID string, note string) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	ret, err := lockReturn(tx, returnID)
+	if err != nil {
+		return err
+	}
+	if ret.Status != ReturnRequested {
+		return ErrReturnResolved
+	}
+
+	if err = updateReturnStatus(tx, returnID, ret.Status, ReturnRejected, nil, OrderActorAdmin, &adminID, note); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// CompleteReturnRefund records that the provider made the refund of an approved
+// return, together with the refund of its payment. Once every line of the order
+// is refunded, the order itself becomes refunded. Completing a refunded return
+// again does nothing.
+func CompleteReturnRefund(returnID int) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	ret, err := lockReturn(tx, returnID)
+	if err != nil || ret.Status == ReturnRefunded {
+		return err
+	}
+	if ret.Status != ReturnApproved {
+		return ErrReturnResolved
+	}
+
+	if err = completePaymentRefund(tx, returnRefundKey(returnID)); err != nil {
+		return err
+	}
+	if err = updateReturnStatus(tx, returnID, ret.Status, ReturnRefunded, nil, OrderActorSystem, nil, ""); err != nil {
+		return err
+	}
+
+	orderStatus, err := lockOrderStatus(tx, ret.OrderID)
+	if err != nil {
+		return err
+	}
+	if orderStatus == OrderStatusDelivered {
+		var remaining int
+		query := `
+		SELECT count(*) FROM order_items oi
+		WHERE oi.order_id = ? AND oi.quantity > (
+			SELECT coalesce(sum(ri.quantity), 0)
+			FROM return_items ri
+			JOIN returns r ON r.id = ri.return_id
+			WHERE r.order_id = oi.order_id AND ri.product_id = oi.product_id AND r.status = ?
+		)
+		`
+		if err = tx.QueryRow(query, ret.OrderID, ReturnRefunded).Scan(&remaining); err != nil {
+			log.Error("Error checking returned items: ", err)
+			return err
+		}
+		if remaining == 0 {
+			note := fmt.Sprintf("all items returned (return %d)", returnID)
+			if err = transitionOrder(tx, ret.OrderID, orderStatus, OrderStatusRefunded, OrderActorSystem, note); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// returnRefundKey is the idempotency key of the refund of a return.
+func returnRefundKey(returnID int) string {
+	return "return-" + strconv.Itoa(returnID)
+}
+
+// returnableQuantities returns how many of each order line may still be returned.
+func returnableQuantities(tx bun.Tx, orderID int) (map[int]int, error) {
+	returnable := make(map[int]int)
+	query := `
+	SELECT oi.product_id, oi.quantity - coalesce((
+		SELECT sum(ri.quantity)
+		FROM return_items ri
+		JOIN returns r ON r.id = ri.return_id
+		WHERE r.order_id = oi.order_id AND ri.product_id = oi.product_id AND r.status <> ?
+	), 0)
+	FROM order_items oi
+	WHERE oi.order_id = ?
+	`
+	rows, err := tx.Query(query, ReturnRejected, orderID)
+	if err != nil {
+		log.Error("Error fetching returnable items: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID, quantity int
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			log.Error("Error scanning returnable item: ", err)
+			return nil, err
+		}
+		returnable[productID] = quantity
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return returnable, nil
+}
+
+// returnRefundAmount calculates the amount to refund for a return being approved.
+// It returns nil if the return covers every line of the order not returned yet,
+// in which case everything left of the payment is refunded.
+func returnRefundAmount(tx bun.Tx, ret Return) (*money.Money, error) {
+	// Все ещё не возвращённые позиции заказа входят в эту заявку: возвращается остаток платежа
+	var remaining int
+	fullQuery := `
+	SELECT count(*) FROM order_items oi
+	WHERE oi.order_id = ? AND oi.quantity > (
+		SELECT coalesce(sum(ri.quantity), 0)
+		FROM return_items ri
+		JOIN returns r ON r.id = ri.return_id
+		WHERE r.order_id = oi.order_id AND ri.product_id = oi.product_id
+		  AND (r.id = ? OR r.status IN (?, ?))
+	)
+	`
+	err := tx.QueryRow(fullQuery, ret.OrderID, ret.ID, ReturnApproved, ReturnRefunded).Scan(&remaining)
+	if err != nil {
+		log.Error("Error checking returned items: ", err)
+		return nil, err
+	}
+	if remaining == 0 {
+		return nil, nil
+	}
+
+	// Частичный возврат: цена позиций за вычетом скидки и доля налога заказа.
+	// Налог начислялся на сумму со скидкой и доставкой, поэтому доля считается от неё
+	var amount money.Money
+	linesQuery := `
+	WITH lines AS (
+		SELECT coalesce(sum((oi.price_at_order * oi.quantity - oi.discount) * ri.quantity / oi.quantity), 0) AS net
+		FROM return_items ri
+		JOIN order_items oi ON oi.order_id = ? AND oi.product_id = ri.product_id
+		WHERE ri.return_id = ?
+	)
+	SELECT round(l.net + coalesce(o.tax * l.net / nullif(o.subtotal - coalesce(o.discount, 0) + o.shipping, 0), 0), 2)
+	FROM lines l, orders o
+	WHERE o.id = ?
+	`
+	if err = tx.QueryRow(linesQuery, ret.OrderID, ret.ID, ret.OrderID).Scan(&amount); err != nil {
+		log.Error("Error calculating refund amount: ", err)
+		return nil, err
+	}
+	return &amount, nil
+}
+
+// queryReturns fetches the returns matching the condition with their items.
+func queryReturns(where string, args ...interface{}) ([]Return, error) {
+	returns := []Return{}
+	query := `SELECT ` + returnColumns + ` FROM returns r WHERE ` + where + ` ORDER BY r.id DESC`
+
+	rows, err := db.Proxy.GetCurrentDB().Query(query, args...)
+	if err != nil {
+		log.Error("Error fetching returns: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		ret, err := scanReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+		returns = append(returns, ret)
+		ids = append(ids, ret.ID)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return returns, nil
+	}
+
+	items, err := getReturnItems(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range returns {
+		returns[i].Items = items[returns[i].ID]
+	}
+
+	return returns, nil
+}
+
+func getReturnItems(returnIDs []int) (map[int][]ReturnItem, error) {
+	items := make(map[int][]ReturnItem, len(returnIDs))
+	query := `
+	SELECT ri.return_id, p.id, p.name, ri.quantity
+	FROM return_items ri
+	JOIN products p ON ri.product_id = p.id
+	WHERE ri.return_id IN (?)
+	ORDER BY ri.return_id, p.id
+	`
+	rows, err := db.Proxy.GetCurrentDB().Query(query, bun.In(returnIDs))
+	if err != nil {
+		log.Error("Error fetching return items: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var returnID int
+		var item ReturnItem
+		if err := rows.Scan(&returnID, &item.ProductID, &item.Product, &item.Quantity); err != nil {
+			log.Error("Error scanning return item: ", err)
+			return nil, err
+		}
+		item.Product = strings.TrimSpace(item.Product)
+		items[returnID] = append(items[returnID], item)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func scanReturn(row interface{ Scan(...interface{}) error }) (Return, error) {
+	var ret Return
+	var userID uuid.UUID
+	err := row.Scan(&ret.ID, &ret.OrderID, &userID, &ret.Status, &ret.Reason, &ret.RefundAmount, &ret.CreatedAt, &ret.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ret, ErrReturnNotFound
+	}
+	if err != nil {
+		log.Error("Error scanning return: ", err)
+		return ret, err
+	}
+	ret.UserID = userID.String()
+	return ret, nil
+}
+
+func lockReturn(tx bun.Tx, returnID int) (Return, error) {
+	return scanReturn(tx.QueryRow(`SELECT `+returnColumns+` FROM returns r WHERE r.id = ? FOR UPDATE`, returnID))
+}
+
+// updateReturnStatus moves a return to the status and records the change in its audit trail.
+// A nil refund amount leaves the saved amount as it is.
+func updateReturnStatus(tx bun.Tx, returnID int, from string, to string, refund *money.Money, actor string, actorID *string, note string) error {
+	query := `UPDATE returns SET status = ?, refund_amount = coalesce(?, refund_amount), updated_at = NOW() WHERE id = ?`
+	if _, err := tx.Exec(query, to, refund, returnID); err != nil {
+		log.Error("Error updating return status: ", err)
+		return err
+	}
+	return recordReturnEvent(tx, returnID, &from, to, actor, actorID, note)
+}
+
+func recordReturnEvent(tx bun.Tx, returnID int, from *string, to string, actor string, actorID *string, note string) error {
+	query := `INSERT INTO return_events (return_id, from_status, to_status, actor, actor_id, note) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := tx.Exec(query, returnID, from, to, actor, actorID, note); err != nil {
+		log.Error("Error recording return event: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/db"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+const (
+	DefaultReviewPageSize = 20
+	MaxReviewPageSize     = 100
+)
+
+var (
+	ErrReviewNotFound     = errors.New("review not found")
+	ErrAlreadyReviewed    = errors.New("product already reviewed")
+	ErrReviewNotPurchased = errors.New("product was not delivered to the user")
+)
+
+type Review struct {
+	ID        int       `bun:"type:int,pk" json:"id"`
+	ProductID int       `bun:"type:int,notnull" json:"product_id"`
+	UserName  string    `json:"user_name"`
+	Rating    int       `bun:"type:smallint,notnull" json:"rating"`
+	Body      string    `bun:"type:text,notnull" json:"text"`
+	CreatedAt time.Time `bun:"type:timestamptz,notnull" json:"created_at"`
+}
+
+type ReviewPage struct {
+	Reviews    []Review `json:"reviews"`
+	NextCursor int      `json:"next_cursor,omitempty"`
+	HasMore    bool     `json:"has_more"`
+}
+
+// CreateReview saves a review of a product the user has received in a delivered order.
+// A user can review each product only once.
+func CreateReview(userID string, review *Review) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Отзыв можно оставить только на доставленный товар
+	var delivered bool
+	deliveredQuery := `
+	SELECT EXISTS(
+		SELECT 1 FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		WHERE o.user_id = ? AND oi.product_id = ? AND o.status = ?
+	)
+	`
+	if err = tx.QueryRow(deliveredQuery, userID, review.ProductID, OrderStatusDelivered).Scan(&delivered); err != nil {
+		log.Error("Error checking delivered orders: ", err)
+		return err
+	}
+	if !delivered {
+		return ErrReviewNotPurchased
+	}
+
+	// Блокировка товара, чтобы параллельные отзывы не перезаписали рейтинг устаревшим значением
+	if err = lockProductRating(tx, review.ProductID); err != nil {
+		return err
+	}
+
+	insertQuery := `
+	INSERT INTO reviews (product_id, user_id, rating, body)
+	VALUES (?, ?, ?, ?)
+	RETURNING id, created_at
+	`
+	err = tx.QueryRow(insertQuery, review.ProductID, userID, review.Rating, review.Body).Scan(&review.ID, &review.CreatedAt)
+	if hasPgErrorCode(err, pgUniqueViolation) {
+		return ErrAlreadyReviewed
+	}
+	if err != nil {
+		log.Error("Error creating review: ", err)
+		return err
+	}
+
+	if err = updateProductRating(tx, review.ProductID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetProductReviews returns visible reviews of a product, newest first.
+// The next page starts after the review with the id passed as cursor.
+func GetProductReviews(productID int, cursor int, limit int) (ReviewPage, error) {
+	page := ReviewPage{Reviews: []Review{}}
+	if limit <= 0 {
+		limit = DefaultReviewPageSize
+	}
+	if limit > MaxReviewPageSize {
+		limit = MaxReviewPageSize
+	}
+
+	query := `
+	SELECT r.id, r.product_id, u.name, r.rating, r.body, r.created_at
+	FROM reviews r
+	JOIN users u ON r.user_id = u.id
+	WHERE r.product_id = ? AND NOT r.hidden AND (? = 0 OR r.id < ?)
+	ORDER BY r.id DESC
+	LIMIT ?
+	`
+	rows, err := db.Proxy.GetReplicaDB().Query(query, productID, cursor, cursor, limit+1)
+	if err != nil {
+		log.Error("Error fetching reviews: ", err)
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var review Review
+		if err := rows.Scan(&review.ID, &review.ProductID, &review.UserName, &review.Rating, &review.Body, &review.CreatedAt); err != nil {
+			log.Error("Error scanning review: ", err)
+			return page, err
+		}
+		review.UserName = strings.TrimSpace(review.UserName)
+		page.Reviews = append(page.Reviews, review)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating rows: ", err)
+		return page, err
+	}
+
+	if len(page.Reviews) > limit {
+		page.Reviews = page.Reviews[:limit]
+		page.HasMore = true
+		page.NextCursor = page.Reviews[limit-1].ID
+	}
+
+	return page, nil
+}
+
+// SetReviewHidden hides a review from the product page or shows it again.
+func SetReviewHidden(reviewID int, hidden bool) error {
+	return moderateReview(reviewID, `UPDATE reviews SET hidden = ? WHERE id = ? RETURNING product_id`, hidden, reviewID)
+}
+
+func DeleteReview(reviewID int) error {
+	return moderateReview(reviewID, `DELETE FROM reviews WHERE id = ? RETURNING product_id`, reviewID)
+}
+
+// moderateReview runs a statement returning the product_id of the changed
+// review and recalculates the rating of that product.
+func moderateReview(reviewID int, query string, args ...interface{}) error {
+	tx, err := db.Proxy.GetPrimaryDB().Begin()
+	if err != nil {
+		log.Error("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var productID int
+	err = tx.QueryRow(`SELECT product_id FROM reviews WHERE id = ?`, reviewID).Scan(&productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		log.Error("Error fetching review: ", err)
+		return err
+	}
+	if err = lockProductRating(tx, productID); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(query, args...).Scan(&productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		log.Error("Error moderating review: ", err)
+		return err
+	}
+
+	if err = updateProductRating(tx, productID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Error("Error committing transaction: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// lockProductRating locks the product row, so that reviews of the product are
+// changed and its rating recalculated by one transaction at a time.
+func lockProductRating(tx bun.Tx, productID int) error {
+	var id int
+	err := tx.QueryRow(`SELECT id FROM products WHERE id = ? FOR UPDATE`, productID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		log.Error("Error locking product: ", err)
+	}
+	return err
+}
+
+// updateProductRating recalculates the average rating and review count of a product.
+func updateProductRating(tx bun.Tx, productID int) error {
+	query := `
+	UPDATE products p
+	SET rating_avg = coalesce(r.average, 0), rating_count = r.count
+	FROM (
+		SELECT avg(rating) AS average, count(*) AS count
+		FROM reviews
+		WHERE product_id = ? AND NOT hidden
+	) r
+	WHERE p.id = ?
+	`
+	if _, err := tx.Exec(query, productID, productID); err != nil {
+		log.Error("Error updating product rating: ", err)
+		return err
+	}
+
+	return nil
+}
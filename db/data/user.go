@@ -12,6 +12,11 @@ import (
 	"github.com/uptrace/bun"
 )
 
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
 	bun.BaseModel `bun:"table:users,alias:u"`
 
@@ -19,15 +24,19 @@ type User struct {
 	Name      string    `bun:"type:char(64),notnull" json:"name"`
 	Email     string    `bun:"type:char(64),unique,notnull" json:"email"`
 	Password  string    `bun:"type:text,notnull" json:"password"`
+	Role      string    `bun:"type:text,notnull,default:'customer'" json:"role"`
+	Currency  string    `bun:"type:char(3),nullzero" json:"currency"`
 	CreatedAt time.Time `bun:"type:timestamptz,default:current_timestamp,notnull" json:"created_at"`
 }
 
+// CreateUser saves a new customer and fills in its generated id and creation time.
 func CreateUser(user *User, c echo.Context) error {
 	query := `
 		INSERT INTO users (name, email, password) VALUES (?, ?, ?)
 		RETURNING id, created_at
 	`
-	_, err := db.Proxy.GetCurrentDB().NewRaw(query, user.Name, user.Email, user.Password).Exec(c.Request().Context())
+	err := db.Proxy.GetPrimaryDB().NewRaw(query, user.Name, user.Email, user.Password).
+		Scan(c.Request().Context(), &user.ID, &user.CreatedAt)
 	if err != nil {
 		log.Error("Error creating user. ", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
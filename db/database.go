@@ -69,6 +69,30 @@ func (manager *DBManager) GetCurrentDB() *bun.DB {
 	return nil
 }
 
+// GetPrimaryDB returns a connection to the primary instance for writes.
+// If no primary is configured or reachable, any available instance is used.
+func (manager *DBManager) GetPrimaryDB() *bun.DB {
+	for i, config := range manager.configs {
+		if config.Role == types.RolePrimary && manager.instances[i] != nil {
+			return manager.instances[i]
+		}
+	}
+	return manager.GetCurrentDB()
+}
+
+// GetReplicaDB returns a read-only replica, rotating between them.
+// If no replica is configured or reachable, any available instance is used.
+func (manager *DBManager) GetReplicaDB() *bun.DB {
+	for i := 0; i < len(manager.instances); i++ {
+		idx := (manager.index + i) % len(manager.instances)
+		if manager.configs[idx].Role == types.RoleReplica && manager.instances[idx] != nil {
+			manager.index = (idx + 1) % len(manager.instances)
+			return manager.instances[idx]
+		}
+	}
+	return manager.GetCurrentDB()
+}
+
 func Init(configPath string) error {
 	config, err := util.LoadConfig(configPath)
 	if err != nil {
@@ -0,0 +1,6 @@
+-- Products need a creation timestamp so the catalog can be ordered by newest.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT current_timestamp;
+
+CREATE INDEX IF NOT EXISTS products_price_id_idx ON products (price, id);
+CREATE INDEX IF NOT EXISTS products_name_id_idx ON products (name, id);
+CREATE INDEX IF NOT EXISTS products_created_at_id_idx ON products (created_at, id);
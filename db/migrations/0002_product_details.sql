@@ -0,0 +1,3 @@
+-- Product detail page shows a description and the quantity in stock.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+ALTER TABLE products ADD COLUMN IF NOT EXISTS stock integer NOT NULL DEFAULT 0;
@@ -0,0 +1,42 @@
+-- Full-text search over name, manufacturer, type and description,
+-- with trigram similarity on the name as a fallback for typos.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION products_search_vector(product products) RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(product.name, '')), 'A')
+        || setweight(to_tsvector('simple', coalesce(product.manufacturer, '')), 'B')
+        || setweight(to_tsvector('simple', coalesce((SELECT name FROM product_types WHERE id = product.product_type_id), '')), 'B')
+        || setweight(to_tsvector('simple', coalesce(product.description, '')), 'C');
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION products_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := products_search_vector(NEW);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_search_vector_trigger ON products;
+CREATE TRIGGER products_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF name, manufacturer, description, product_type_id ON products
+    FOR EACH ROW EXECUTE FUNCTION products_search_vector_update();
+
+-- Renaming a product type changes the search vector of all its products.
+CREATE OR REPLACE FUNCTION product_types_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    UPDATE products p SET search_vector = products_search_vector(p) WHERE p.product_type_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS product_types_search_vector_trigger ON product_types;
+CREATE TRIGGER product_types_search_vector_trigger
+    AFTER UPDATE OF name ON product_types
+    FOR EACH ROW EXECUTE FUNCTION product_types_search_vector_update();
+
+UPDATE products p SET search_vector = products_search_vector(p);
+
+CREATE INDEX IF NOT EXISTS products_search_vector_idx ON products USING gin (search_vector);
+CREATE INDEX IF NOT EXISTS products_name_trgm_idx ON products USING gin ((name::text) gin_trgm_ops);
@@ -0,0 +1,15 @@
+-- Product types become a category tree addressed by slug.
+ALTER TABLE product_types ADD COLUMN IF NOT EXISTS parent_id integer REFERENCES product_types (id) ON DELETE SET NULL;
+ALTER TABLE product_types ADD COLUMN IF NOT EXISTS slug text;
+
+UPDATE product_types
+SET slug = trim(both '-' from lower(regexp_replace(trim(name), '[^[:alnum:]]+', '-', 'g')))
+WHERE slug IS NULL;
+
+UPDATE product_types pt
+SET slug = pt.slug || '-' || pt.id
+WHERE pt.slug = '' OR EXISTS (SELECT 1 FROM product_types o WHERE o.slug = pt.slug AND o.id < pt.id);
+
+ALTER TABLE product_types ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS product_types_slug_idx ON product_types (slug);
+CREATE INDEX IF NOT EXISTS product_types_parent_id_idx ON product_types (parent_id);
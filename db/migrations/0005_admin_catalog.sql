@@ -0,0 +1,6 @@
+-- Users get a role; admins manage the catalog.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'customer';
+
+-- Products and product types are soft-deleted so they can be restored.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE product_types ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
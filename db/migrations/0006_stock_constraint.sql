@@ -0,0 +1,3 @@
+-- Stock can never go below zero, even if two orders race for the last items.
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_stock_non_negative;
+ALTER TABLE products ADD CONSTRAINT products_stock_non_negative CHECK (stock >= 0);
@@ -0,0 +1,10 @@
+-- Stock held for items sitting in a cart until the reservation expires.
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    user_id    uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    product_id integer     NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    quantity   integer     NOT NULL CHECK (quantity > 0),
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY (user_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_product_id_idx ON stock_reservations (product_id, expires_at);
@@ -0,0 +1,4 @@
+-- SKU identifies a product in catalog imports and exports.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sku text;
+UPDATE products SET sku = 'SKU-' || id WHERE sku IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS products_sku_idx ON products (sku);
@@ -0,0 +1,12 @@
+-- Images of a product, stored in the media storage under the given keys.
+CREATE TABLE IF NOT EXISTS product_images (
+    id            serial PRIMARY KEY,
+    product_id    integer     NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    key           text        NOT NULL,
+    thumbnail_key text        NOT NULL,
+    content_type  text        NOT NULL,
+    position      integer     NOT NULL DEFAULT 0,
+    created_at    timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS product_images_product_id_idx ON product_images (product_id, position, id);
@@ -0,0 +1,19 @@
+-- Orders get a status; only delivered orders allow reviewing their products.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'pending';
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id         serial PRIMARY KEY,
+    product_id integer     NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    user_id    uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    rating     smallint    NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body       text        NOT NULL DEFAULT '',
+    hidden     boolean     NOT NULL DEFAULT false,
+    created_at timestamptz NOT NULL DEFAULT current_timestamp,
+    UNIQUE (product_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_product_id_idx ON reviews (product_id, id);
+
+-- Average rating and number of visible reviews, kept up to date on every review change.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_avg numeric(3, 2) NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
@@ -0,0 +1,48 @@
+-- Every price a product has had, recorded by a trigger whatever changed it.
+CREATE TABLE IF NOT EXISTS price_history (
+    id         serial PRIMARY KEY,
+    product_id integer       NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    price      decimal(10,2) NOT NULL,
+    valid_from timestamptz   NOT NULL DEFAULT current_timestamp,
+    valid_to   timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS price_history_product_id_idx ON price_history (product_id, valid_from);
+
+CREATE OR REPLACE FUNCTION products_price_history() RETURNS trigger AS $$
+BEGIN
+    UPDATE price_history SET valid_to = NOW() WHERE product_id = NEW.id AND valid_to IS NULL;
+    INSERT INTO price_history (product_id, price) VALUES (NEW.id, NEW.price);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_price_history_insert ON products;
+CREATE TRIGGER products_price_history_insert
+    AFTER INSERT ON products
+    FOR EACH ROW EXECUTE FUNCTION products_price_history();
+
+DROP TRIGGER IF EXISTS products_price_history_update ON products;
+CREATE TRIGGER products_price_history_update
+    AFTER UPDATE OF price ON products
+    FOR EACH ROW WHEN (OLD.price IS DISTINCT FROM NEW.price)
+    EXECUTE FUNCTION products_price_history();
+
+INSERT INTO price_history (product_id, price)
+SELECT p.id, p.price FROM products p
+WHERE NOT EXISTS (SELECT 1 FROM price_history h WHERE h.product_id = p.id);
+
+-- Price changes applied by a background job between starts_at and ends_at.
+-- Without ends_at the new price stays. previous_price is what gets restored at ends_at.
+CREATE TABLE IF NOT EXISTS scheduled_price_changes (
+    id             serial PRIMARY KEY,
+    product_id     integer       NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    price          decimal(10,2) NOT NULL CHECK (price >= 0),
+    starts_at      timestamptz   NOT NULL,
+    ends_at        timestamptz CHECK (ends_at > starts_at),
+    status         text          NOT NULL DEFAULT 'scheduled',
+    previous_price decimal(10,2),
+    created_at     timestamptz   NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_price_changes_status_idx ON scheduled_price_changes (status, starts_at);
@@ -0,0 +1,14 @@
+-- Rates from the base currency, managed by admins.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    currency   char(3)        PRIMARY KEY,
+    rate       numeric(18, 8) NOT NULL CHECK (rate > 0),
+    updated_at timestamptz    NOT NULL DEFAULT current_timestamp
+);
+
+-- Currency prices are shown in when the request does not ask for one.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency char(3);
+
+-- Orders remember the currency and rate they were placed with.
+-- NULL means the base currency for orders placed before this change.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency char(3);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS exchange_rate numeric(18, 8);
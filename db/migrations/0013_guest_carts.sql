@@ -0,0 +1,8 @@
+-- Carts of visitors who are not logged in, keyed by an anonymous session id.
+ALTER TABLE cart ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS guest_id uuid UNIQUE;
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT current_timestamp;
+ALTER TABLE cart DROP CONSTRAINT IF EXISTS cart_owner_check;
+ALTER TABLE cart ADD CONSTRAINT cart_owner_check CHECK (user_id IS NOT NULL OR guest_id IS NOT NULL);
+
+CREATE INDEX IF NOT EXISTS cart_guest_updated_at_idx ON cart (updated_at) WHERE guest_id IS NOT NULL;
@@ -0,0 +1,40 @@
+-- Discount codes. value is a percentage for 'percent' coupons and an amount
+-- in the base currency for 'fixed' ones.
+CREATE TABLE IF NOT EXISTS coupons (
+    id                serial PRIMARY KEY,
+    code              text           NOT NULL,
+    kind              text           NOT NULL CHECK (kind IN ('percent', 'fixed')),
+    value             numeric(10, 2) NOT NULL CHECK (value > 0),
+    min_order         numeric(10, 2),
+    product_id        integer REFERENCES products (id),
+    category_id       integer REFERENCES product_types (id),
+    max_uses          integer CHECK (max_uses > 0),
+    max_uses_per_user integer CHECK (max_uses_per_user > 0),
+    uses              integer        NOT NULL DEFAULT 0,
+    starts_at         timestamptz,
+    ends_at           timestamptz,
+    disabled_at       timestamptz,
+    created_at        timestamptz    NOT NULL DEFAULT current_timestamp,
+    CHECK (kind <> 'percent' OR value <= 100),
+    CHECK (ends_at IS NULL OR starts_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS coupons_code_idx ON coupons (upper(code));
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    coupon_id   integer        NOT NULL REFERENCES coupons (id),
+    user_id     uuid           NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    order_id    integer        NOT NULL REFERENCES orders (id),
+    discount    numeric(10, 2) NOT NULL,
+    redeemed_at timestamptz    NOT NULL DEFAULT current_timestamp,
+    PRIMARY KEY (order_id)
+);
+
+CREATE INDEX IF NOT EXISTS coupon_redemptions_coupon_user_idx ON coupon_redemptions (coupon_id, user_id);
+
+-- The coupon applied to a cart is checked again when the order is placed.
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS coupon_id integer REFERENCES coupons (id) ON DELETE SET NULL;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_id integer REFERENCES coupons (id);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount numeric(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount numeric(10, 2) NOT NULL DEFAULT 0;
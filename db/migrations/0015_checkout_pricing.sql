@@ -0,0 +1,18 @@
+-- Weight used by weight-based shipping.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS weight_grams integer NOT NULL DEFAULT 0 CHECK (weight_grams >= 0);
+
+-- Tax rates in percent by region, e.g. 'DE' or 'US-CA'.
+-- A region without its own rate falls back to the rate of its country.
+CREATE TABLE IF NOT EXISTS tax_rates (
+    region     text          PRIMARY KEY,
+    rate       numeric(6, 3) NOT NULL CHECK (rate >= 0 AND rate <= 100),
+    updated_at timestamptz   NOT NULL DEFAULT current_timestamp
+);
+
+-- Price breakdown of the order in the base currency.
+-- NULL subtotal and total mean the order was placed before this change.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS region text;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal numeric(10, 2);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping numeric(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax numeric(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS total numeric(10, 2);
@@ -0,0 +1,23 @@
+-- Orders are never deleted; cancelling moves them to the cancelled status.
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded'));
+
+-- Every status change of an order. from_status is NULL for the event created with the order.
+CREATE TABLE IF NOT EXISTS order_events (
+    id          serial PRIMARY KEY,
+    order_id    integer     NOT NULL REFERENCES orders (id),
+    from_status text,
+    to_status   text        NOT NULL,
+    actor       text        NOT NULL,
+    note        text        NOT NULL DEFAULT '',
+    created_at  timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id, id);
+
+-- Existing orders start their history with their current status.
+INSERT INTO order_events (order_id, to_status, actor, created_at)
+SELECT o.id, o.status, 'system', o.order_date
+FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM order_events e WHERE e.order_id = o.id);
@@ -0,0 +1,15 @@
+-- Responses of requests sent with an Idempotency-Key header, replayed for retries.
+-- A NULL status_code means the first request is still being processed.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id       uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    key           text        NOT NULL,
+    request_hash  text        NOT NULL,
+    status_code   integer,
+    content_type  text,
+    response_body text,
+    created_at    timestamptz NOT NULL DEFAULT current_timestamp,
+    expires_at    timestamptz NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,18 @@
+-- Orders whose payment was declined end in the failed status.
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded', 'failed'));
+
+-- One payment per order, in the base currency. reference is the id at the provider.
+CREATE TABLE IF NOT EXISTS payments (
+    id              uuid PRIMARY KEY,
+    order_id        integer        NOT NULL UNIQUE REFERENCES orders (id),
+    provider        text,
+    reference       text,
+    amount          numeric(10, 2) NOT NULL CHECK (amount >= 0),
+    refunded_amount numeric(10, 2) NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0 AND refunded_amount <= amount),
+    status          text           NOT NULL CHECK (status IN ('pending', 'authorized', 'captured', 'failed', 'refunded')),
+    failure_reason  text           NOT NULL DEFAULT '',
+    created_at      timestamptz    NOT NULL DEFAULT current_timestamp,
+    updated_at      timestamptz    NOT NULL DEFAULT current_timestamp
+);
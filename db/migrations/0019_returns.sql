@@ -0,0 +1,38 @@
+-- Return requests (RMA) for lines of delivered orders.
+-- refund_amount is set on approval, in the base currency.
+CREATE TABLE IF NOT EXISTS returns (
+    id            serial PRIMARY KEY,
+    order_id      integer        NOT NULL REFERENCES orders (id),
+    user_id       uuid           NOT NULL,
+    status        text           NOT NULL CHECK (status IN ('requested', 'approved', 'rejected', 'refunded')),
+    reason        text           NOT NULL,
+    refund_amount numeric(10, 2) CHECK (refund_amount >= 0),
+    created_at    timestamptz    NOT NULL DEFAULT current_timestamp,
+    updated_at    timestamptz    NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS returns_order_id_idx ON returns (order_id);
+CREATE INDEX IF NOT EXISTS returns_user_id_idx ON returns (user_id, id);
+CREATE INDEX IF NOT EXISTS returns_status_idx ON returns (status, id);
+
+-- The order lines and quantities being returned.
+CREATE TABLE IF NOT EXISTS return_items (
+    return_id  integer NOT NULL REFERENCES returns (id) ON DELETE CASCADE,
+    product_id integer NOT NULL REFERENCES products (id),
+    quantity   integer NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (return_id, product_id)
+);
+
+-- Audit trail of every status change of a return. from_status is NULL for the request itself.
+CREATE TABLE IF NOT EXISTS return_events (
+    id          serial PRIMARY KEY,
+    return_id   integer     NOT NULL REFERENCES returns (id) ON DELETE CASCADE,
+    from_status text,
+    to_status   text        NOT NULL,
+    actor       text        NOT NULL,
+    actor_id    uuid,
+    note        text        NOT NULL DEFAULT '',
+    created_at  timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS return_events_return_id_idx ON return_events (return_id, id);
@@ -0,0 +1,23 @@
+-- Saved delivery addresses. A user has at most one default address.
+CREATE TABLE IF NOT EXISTS addresses (
+    id         serial PRIMARY KEY,
+    user_id    uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       text        NOT NULL,
+    line1      text        NOT NULL,
+    line2      text        NOT NULL DEFAULT '',
+    city       text        NOT NULL,
+    postcode   text        NOT NULL DEFAULT '',
+    country    char(2)     NOT NULL,
+    phone      text        NOT NULL DEFAULT '',
+    is_default boolean     NOT NULL DEFAULT false,
+    created_at timestamptz NOT NULL DEFAULT current_timestamp,
+    updated_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS addresses_user_id_idx ON addresses (user_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS addresses_default_idx ON addresses (user_id) WHERE is_default;
+
+-- Orders keep a copy of the address they were placed with, so editing or
+-- deleting the saved address does not change past orders.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS address_id integer REFERENCES addresses (id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_address jsonb;
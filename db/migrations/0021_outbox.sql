@@ -0,0 +1,16 @@
+-- Domain events written in the same transaction as the change they describe.
+-- The relay publishes unpublished events and retries failures after next_attempt_at.
+CREATE TABLE IF NOT EXISTS outbox (
+    id              bigserial PRIMARY KEY,
+    topic           text        NOT NULL,
+    key             text        NOT NULL,
+    payload         jsonb       NOT NULL,
+    attempts        integer     NOT NULL DEFAULT 0,
+    last_error      text        NOT NULL DEFAULT '',
+    created_at      timestamptz NOT NULL DEFAULT current_timestamp,
+    next_attempt_at timestamptz NOT NULL DEFAULT current_timestamp,
+    published_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at, id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_published_at_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
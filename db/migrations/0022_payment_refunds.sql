@@ -0,0 +1,16 @@
+-- Every refund of a payment, keyed by the idempotency key sent to the provider.
+-- A refund is stored as pending before the provider is called, so a retry reuses
+-- the same key and amount instead of refunding twice. Refunds of returns are
+-- keyed by their return.
+CREATE TABLE IF NOT EXISTS payment_refunds (
+    id           serial PRIMARY KEY,
+    payment_id   uuid           NOT NULL REFERENCES payments (id),
+    key          text           NOT NULL UNIQUE,
+    return_id    integer        UNIQUE REFERENCES returns (id),
+    amount       numeric(10, 2) NOT NULL CHECK (amount >= 0),
+    status       text           NOT NULL CHECK (status IN ('pending', 'succeeded')),
+    created_at   timestamptz    NOT NULL DEFAULT current_timestamp,
+    completed_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS payment_refunds_payment_id_idx ON payment_refunds (payment_id);
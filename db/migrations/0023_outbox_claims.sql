@@ -0,0 +1,5 @@
+-- The relay claims events until claimed_until and publishes them outside of a transaction.
+-- An event is only claimed once the older unpublished events of its key are published.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until timestamptz;
+
+CREATE INDEX IF NOT EXISTS outbox_pending_key_idx ON outbox (key, id) WHERE published_at IS NULL;
@@ -0,0 +1,4 @@
+-- name is char(128) and the trigram opclass is defined for text, so the index
+-- is built on name::text, the expression the product search filters on.
+DROP INDEX IF EXISTS products_name_trgm_idx;
+CREATE INDEX IF NOT EXISTS products_name_trgm_idx ON products USING gin ((name::text) gin_trgm_ops);
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+var (
+	countryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+	phonePattern    = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,19}$`)
+	postcodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,9}$`)
+)
+
+// postcodePatterns holds the postcode format of countries we know. Other
+// countries only need a plausible postcode.
+var postcodePatterns = map[string]*regexp.Regexp{
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"RU": regexp.MustCompile(`^\d{6}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+}
+
+// countriesWithoutPostcodes do not use postcodes, so none is required.
+var countriesWithoutPostcodes = map[string]bool{
+	"AE": true,
+	"HK": true,
+	"QA": true,
+}
+
+func GetAddresses(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	addresses, err := data.GetAddresses(owner.String())
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching addresses. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"addresses": addresses,
+	})
+}
+
+func GetAddress(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid address id.")
+	}
+
+	address, err := data.GetAddress(owner.String(), id)
+	if errors.Is(err, data.ErrAddressNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Address not found.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching address. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"address": address,
+	})
+}
+
+func CreateAddress(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	var address data.Address
+	if err := c.Bind(&address); err != nil {
+		log.Error("Error binding request data. Address was not created.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	if message := validateAddress(&address); message != "" {
+		return util.JsonResponse(c, http.StatusBadRequest, message)
+	}
+
+	if err := data.CreateAddress(owner.String(), &address); err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error saving address. Please try again later.")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"address": address,
+	})
+}
+
+func UpdateAddress(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid address id.")
+	}
+
+	var address data.Address
+	if err := c.Bind(&address); err != nil {
+		log.Error("Error binding request data. Address was not updated.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	address.ID = id
+	if message := validateAddress(&address); message != "" {
+		return util.JsonResponse(c, http.StatusBadRequest, message)
+	}
+
+	err = data.UpdateAddress(owner.String(), &address)
+	if errors.Is(err, data.ErrAddressNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Address not found.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error saving address. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"address": address,
+	})
+}
+
+func DeleteAddress(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid address id.")
+	}
+
+	err = data.DeleteAddress(owner.String(), id)
+	if errors.Is(err, data.ErrAddressNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Address not found.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error deleting address. Please try again later.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Address deleted.")
+}
+
+// validateAddress normalizes the address and returns a message describing the
+// first invalid field, or an empty string if the address is valid.
+func validateAddress(address *data.Address) string {
+	address.Name = strings.TrimSpace(address.Name)
+	address.Line1 = strings.TrimSpace(address.Line1)
+	address.Line2 = strings.TrimSpace(address.Line2)
+	address.City = strings.TrimSpace(address.City)
+	address.Postcode = strings.ToUpper(strings.TrimSpace(address.Postcode))
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	address.Phone = strings.TrimSpace(address.Phone)
+
+	switch {
+	case address.Name == "" || len(address.Name) > 100:
+		return "Name is required and must not exceed 100 characters."
+	case address.Line1 == "" || len(address.Line1) > 100 || len(address.Line2) > 100:
+		return "Address line is required and lines must not exceed 100 characters."
+	case address.City == "" || len(address.City) > 100:
+		return "City is required and must not exceed 100 characters."
+	case !countryPattern.MatchString(address.Country):
+		return "Country must be a two-letter ISO code."
+	case address.Phone != "" && !phonePattern.MatchString(address.Phone):
+		return "Invalid phone number."
+	}
+
+	if countriesWithoutPostcodes[address.Country] {
+		address.Postcode = ""
+		return ""
+	}
+	pattern, ok := postcodePatterns[address.Country]
+	if !ok {
+		pattern = postcodePattern
+	}
+	if !pattern.MatchString(address.Postcode) {
+		return "Invalid postcode for " + address.Country + "."
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+func CreateProduct(c echo.Context) error {
+	input, err := bindProductInput(c)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	id, err := data.CreateProduct(input)
+	if err != nil {
+		return catalogWriteError(c, err, "Error creating product.")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Product created.",
+		"id":      id,
+	})
+}
+
+func UpdateProduct(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	input, err := bindProductInput(c)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := data.UpdateProduct(id, input); err != nil {
+		return catalogWriteError(c, err, "Error updating product.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Product updated.")
+}
+
+func DeleteProduct(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	if err := data.DeleteProduct(id); err != nil {
+		return catalogWriteError(c, err, "Error deleting product.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Product deleted.")
+}
+
+func RestoreProduct(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	if err := data.RestoreProduct(id); err != nil {
+		return catalogWriteError(c, err, "Error restoring product.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Product restored.")
+}
+
+func CreateProductType(c echo.Context) error {
+	input, err := bindProductTypeInput(c)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	id, err := data.CreateProductType(input)
+	if err != nil {
+		return catalogWriteError(c, err, "Error creating product type.")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "Product type created.",
+		"id":      id,
+		"slug":    input.Slug,
+	})
+}
+
+func UpdateProductType(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product type id.")
+	}
+
+	input, err := bindProductTypeInput(c)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, err.Error())
+	}
+	if input.ParentID != nil && *input.ParentID == id {
+		return util.JsonResponse(c, http.StatusBadRequest, "Product type cannot be its own parent.")
+	}
+
+	if err := data.UpdateProductType(id, input); err != nil {
+		return catalogWriteError(c, err, "Error updating product type.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Product type updated.")
+}
+
+func DeleteProductType(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product type id.")
+	}
+
+	if err := data.DeleteProductType(id); err != nil {
+		return catalogWriteError(c, err, "Error deleting product type.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Product type deleted.")
+}
+
+func RestoreProductType(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product type id.")
+	}
+
+	if err := data.RestoreProductType(id); err != nil {
+		return catalogWriteError(c, err, "Error restoring product type.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Product type restored.")
+}
+
+func bindProductInput(c echo.Context) (data.ProductInput, error) {
+	var input data.ProductInput
+	if err := c.Bind(&input); err != nil {
+		log.Error("Error binding request data. Product was not saved.")
+		return input, errors.New("Invalid request.")
+	}
+
+	input.SKU = strings.TrimSpace(input.SKU)
+	input.Name = strings.TrimSpace(input.Name)
+	input.Manufacturer = strings.TrimSpace(input.Manufacturer)
+	switch {
+	case input.Name == "":
+		return input, errors.New("Product name is required.")
+	case input.Price.IsNegative():
+		return input, errors.New("Price must not be negative.")
+	case input.Stock < 0:
+		return input, errors.New("Stock must not be negative.")
+	case input.WeightGrams < 0:
+		return input, errors.New("Weight must not be negative.")
+	case input.ProductTypeID <= 0:
+		return input, errors.New("Product type is required.")
+	}
+
+	return input, nil
+}
+
+func bindProductTypeInput(c echo.Context) (data.ProductTypeInput, error) {
+	var input data.ProductTypeInput
+	if err := c.Bind(&input); err != nil {
+		log.Error("Error binding request data. Product type was not saved.")
+		return input, errors.New("Invalid request.")
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return input, errors.New("Product type name is required.")
+	}
+	if input.Slug == "" {
+		input.Slug = util.Slugify(input.Name)
+	}
+	if input.Slug != util.Slugify(input.Slug) || input.Slug == "" {
+		return input, errors.New("Invalid slug.")
+	}
+
+	return input, nil
+}
+
+// catalogWriteError maps errors of the admin catalog operations to responses.
+func catalogWriteError(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, data.ErrProductNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Product not found.")
+	case errors.Is(err, data.ErrCategoryNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Product type not found.")
+	case errors.Is(err, data.ErrSlugTaken):
+		return util.JsonResponse(c, http.StatusConflict, "Slug is already taken.")
+	case errors.Is(err, data.ErrSKUTaken):
+		return util.JsonResponse(c, http.StatusConflict, "SKU is already taken.")
+	case errors.Is(err, data.ErrProductTypeCycle):
+		return util.JsonResponse(c, http.StatusBadRequest, "Product type cannot be moved under itself or its descendants.")
+	}
+
+	log.Error("Database query failed: ", err)
+	return util.JsonResponse(c, http.StatusInternalServerError, message)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/catalog"
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// exportFlushEvery is the number of exported products after which the response is flushed.
+const exportFlushEvery = 100
+
+// ImportProducts upserts products from an uploaded CSV or JSON file.
+//
+// The file is taken from the "file" form field, or from the request body if there is none.
+// The format is set by the "format" query parameter and defaults to the file extension.
+// With dry_run=true nothing is saved, but the report shows what would have happened.
+func ImportProducts(c echo.Context) error {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+	format := c.QueryParam("format")
+
+	var body io.Reader = c.Request().Body
+	if file, err := c.FormFile("file"); err == nil {
+		src, err := file.Open()
+		if err != nil {
+			log.Error("Error opening uploaded file: ", err)
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid file.")
+		}
+		defer src.Close()
+		body = src
+
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+		}
+	}
+	if format == "" {
+		format = catalog.FormatCSV
+	}
+
+	records, rowErrors, err := catalog.Read(body, format)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid file: "+err.Error())
+	}
+
+	report, err := data.ImportProducts(c.Request().Context(), records, rowErrors, dryRun)
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error importing products.")
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// ExportProducts streams the whole catalog as CSV or JSON.
+func ExportProducts(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = catalog.FormatCSV
+	}
+
+	writer, err := catalog.NewWriter(c.Response(), format)
+	if errors.Is(err, catalog.ErrUnsupportedFormat) {
+		return util.JsonResponse(c, http.StatusBadRequest, "Unsupported format. Use csv or json.")
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == catalog.FormatJSON {
+		contentType = echo.MIMEApplicationJSONCharsetUTF8
+	}
+	filename := fmt.Sprintf("products-%s.%s", time.Now().Format("2006-01-02"), format)
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	count := 0
+	err = data.ExportProducts(c.Request().Context(), func(record data.ProductRecord) error {
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			c.Response().Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// Заголовки уже отправлены, поэтому остаётся только прервать ответ
+		log.Error("Error exporting products: ", err)
+		return nil
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Error("Error finishing export: ", err)
+	}
+	c.Response().Flush()
+
+	return nil
+}
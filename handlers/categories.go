@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+func GetCategories(c echo.Context) error {
+	categories, err := data.GetCategories()
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching categories. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"categories": categories,
+	})
+}
+
+// GetCategoryProducts lists products of a category and its subcategories.
+// It accepts the same query parameters as GetProducts.
+func GetCategoryProducts(c echo.Context) error {
+	category, err := data.GetCategoryBySlug(c.Param("slug"))
+	if errors.Is(err, data.ErrCategoryNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Category not found.")
+	}
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching category. Please try again later.")
+	}
+
+	filter, err := bindProductFilter(c)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, err.Error())
+	}
+	filter.CategorySlug = category.Slug
+
+	return respondWithProducts(c, filter)
+}
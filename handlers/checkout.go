@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/Lexxxzy/go-echo-template/pricing"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// Regions are country codes optionally followed by a subdivision, e.g. "DE" or "US-CA".
+var regionPattern = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// QuoteCheckout previews subtotal, discount, shipping, tax and total of the cart.
+func QuoteCheckout(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	var request = struct {
+		Region string `json:"region"`
+	}{}
+
+	if err := c.Bind(&request); err != nil {
+		log.Error("Error binding request data. Checkout was not quoted.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	region := strings.ToUpper(strings.TrimSpace(request.Region))
+	if region != "" && !regionPattern.MatchString(region) {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid region.")
+	}
+
+	quote, err := data.QuoteCart(owner.String(), region)
+	if errors.Is(err, data.ErrCartEmpty) {
+		return util.JsonResponse(c, http.StatusBadRequest, "Cart is empty.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error calculating checkout. Please try again later.")
+	}
+
+	if err := convertQuote(&quote, displayRate(c)); err != nil {
+		log.Error("Error converting checkout quote: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error calculating checkout. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"quote": quote,
+	})
+}
+
+func GetTaxRates(c echo.Context) error {
+	rates, err := data.GetTaxRates()
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching tax rates.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tax_rates": rates,
+	})
+}
+
+func SetTaxRate(c echo.Context) error {
+	var request = struct {
+		Rate string `json:"rate"`
+	}{}
+
+	if err := c.Bind(&request); err != nil {
+		log.Error("Error binding request data. Tax rate was not saved.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+
+	region := strings.ToUpper(c.Param("region"))
+	if !regionPattern.MatchString(region) {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid region.")
+	}
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(request.Rate))
+	if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(100, 1)) > 0 {
+		return util.JsonResponse(c, http.StatusBadRequest, "Rate must be a percentage between 0 and 100.")
+	}
+
+	if err := data.SetTaxRate(region, rate.FloatString(3)); err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error saving tax rate.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Tax rate saved.")
+}
+
+func DeleteTaxRate(c echo.Context) error {
+	err := data.DeleteTaxRate(c.Param("region"))
+	if errors.Is(err, data.ErrTaxRateNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Tax rate not found.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error deleting tax rate.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Tax rate deleted.")
+}
+
+// convertQuote converts every part of the quote and sums the converted parts,
+// so the total always matches the breakdown.
+func convertQuote(quote *pricing.Quote, rate money.Rate) error {
+	quote.Subtotal = quote.Subtotal.Convert(rate)
+	quote.Discount = quote.Discount.Convert(rate)
+	quote.Shipping = quote.Shipping.Convert(rate)
+	quote.Tax = quote.Tax.Convert(rate)
+	total, err := money.Sum(quote.Subtotal, quote.Discount.Neg(), quote.Shipping, quote.Tax)
+	if err != nil {
+		return err
+	}
+	quote.Total = total
+	return nil
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// ApplyCoupon applies a discount code to the user's cart.
+func ApplyCoupon(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	var request = struct {
+		Code string `json:"code"`
+	}{}
+
+	if err := c.Bind(&request); err != nil || strings.TrimSpace(request.Code) == "" {
+		log.Error("Error binding request data. Coupon was not applied.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+
+	discount, err := data.ApplyCartCoupon(owner.String(), request.Code)
+	if err != nil {
+		if err, done := respondCouponError(c, err); done {
+			return err
+		}
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error applying coupon. Please try again later.")
+	}
+
+	if err := convertDiscount(&discount, displayRate(c)); err != nil {
+		log.Error("Error converting coupon discount: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error applying coupon. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "Coupon applied.",
+		"discount": discount,
+	})
+}
+
+func RemoveCoupon(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	if err := data.RemoveCartCoupon(owner.String()); err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error removing coupon. Please try again later.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Coupon removed.")
+}
+
+func GetCoupons(c echo.Context) error {
+	coupons, err := data.GetCoupons()
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching coupons.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"coupons": coupons,
+	})
+}
+
+func CreateCoupon(c echo.Context) error {
+	var coupon data.Coupon
+	if err := c.Bind(&coupon); err != nil {
+		log.Error("Error binding request data. Coupon was not created.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+
+	coupon.Code = strings.TrimSpace(coupon.Code)
+	value, err := money.Parse(coupon.Value.String(), money.DefaultCurrency)
+	switch {
+	case coupon.Code == "":
+		return util.JsonResponse(c, http.StatusBadRequest, "Coupon code is required.")
+	case coupon.Kind != data.CouponPercent && coupon.Kind != data.CouponFixed:
+		return util.JsonResponse(c, http.StatusBadRequest, "Coupon kind must be percent or fixed.")
+	case err != nil || value.Amount <= 0:
+		return util.JsonResponse(c, http.StatusBadRequest, "Coupon value must be a positive number.")
+	case coupon.Kind == data.CouponPercent && value.Amount > 100*100:
+		return util.JsonResponse(c, http.StatusBadRequest, "Percentage must not exceed 100.")
+	case coupon.MinOrder != nil && coupon.MinOrder.IsNegative():
+		return util.JsonResponse(c, http.StatusBadRequest, "Minimum order must not be negative.")
+	case coupon.MaxUses != nil && *coupon.MaxUses <= 0,
+		coupon.MaxUsesPerUser != nil && *coupon.MaxUsesPerUser <= 0:
+		return util.JsonResponse(c, http.StatusBadRequest, "Usage limits must be positive.")
+	case coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.EndsAt.After(*coupon.StartsAt):
+		return util.JsonResponse(c, http.StatusBadRequest, "End time must be after start time.")
+	}
+	coupon.Value = json.Number(value.String())
+
+	err = data.CreateCoupon(&coupon)
+	switch {
+	case errors.Is(err, data.ErrCouponCodeTaken):
+		return util.JsonResponse(c, http.StatusConflict, "Coupon code is already taken.")
+	case errors.Is(err, data.ErrCouponScopeNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Product or category not found.")
+	case err != nil:
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error creating coupon.")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"coupon": coupon,
+	})
+}
+
+func DisableCoupon(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid coupon id.")
+	}
+
+	err = data.DisableCoupon(id)
+	if errors.Is(err, data.ErrCouponNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "No active coupon with this id.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error disabling coupon.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Coupon disabled.")
+}
+
+// convertDiscount converts every line of the discount and sums the converted lines,
+// so the total always matches the breakdown.
+func convertDiscount(discount *data.CartDiscount, rate money.Rate) error {
+	discount.Total = money.New(0, rate.Currency)
+	for i := range discount.Lines {
+		discount.Lines[i].Discount = discount.Lines[i].Discount.Convert(rate)
+		var err error
+		if discount.Total, err = discount.Total.Add(discount.Lines[i].Discount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// respondCouponError writes a response explaining why a coupon cannot be used.
+func respondCouponError(c echo.Context, err error) (error, bool) {
+	switch {
+	case errors.Is(err, data.ErrCouponNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Coupon not found."), true
+	case errors.Is(err, data.ErrCouponInactive):
+		return util.JsonResponse(c, http.StatusBadRequest, "Coupon is not active."), true
+	case errors.Is(err, data.ErrCouponUsedUp):
+		return util.JsonResponse(c, http.StatusConflict, "Coupon usage limit reached."), true
+	case errors.Is(err, data.ErrCouponMinOrder):
+		return util.JsonResponse(c, http.StatusBadRequest, "Order total is below the coupon minimum."), true
+	case errors.Is(err, data.ErrCouponNotApplicable):
+		return util.JsonResponse(c, http.StatusBadRequest, "Coupon does not apply to any item in the cart."), true
+	}
+	return nil, false
+}
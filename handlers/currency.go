@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/money"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// WithCurrency is a middleware that selects the currency prices are shown in.
+// It is attached only to routes that return prices.
+//
+// The currency is taken from the "currency" query parameter, then from the user's
+// preference saved in the session, and defaults to the base currency.
+// The exchange rate is set in the context as "rate".
+// An unknown currency in the query results in a 400 response. A saved preference
+// whose rate was deleted falls back to the base currency.
+func WithCurrency(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		currency := c.QueryParam("currency")
+		fromSession := false
+		if currency == "" {
+			if sess, err := session.Get("session", c); err == nil {
+				currency, _ = sess.Values["currency"].(string)
+				fromSession = true
+			}
+		}
+
+		rate, err := data.GetRate(currency)
+		if errors.Is(err, data.ErrUnknownCurrency) {
+			if !fromSession {
+				return util.JsonResponse(c, http.StatusBadRequest, "Unknown currency.")
+			}
+			rate, err = money.BaseRate(), nil
+		}
+		if err != nil {
+			log.Error("Database query failed: ", err)
+			return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching exchange rate.")
+		}
+
+		c.Set("rate", rate)
+
+		return next(c)
+	}
+}
+
+// displayRate returns the rate selected by WithCurrency.
+func displayRate(c echo.Context) money.Rate {
+	if rate, ok := c.Get("rate").(money.Rate); ok {
+		return rate
+	}
+	return money.BaseRate()
+}
+
+func convertProducts(products []data.Product, rate money.Rate) {
+	for i := range products {
+		products[i].Price = products[i].Price.Convert(rate)
+	}
+}
+
+func convertCartItems(items []data.CartItem, rate money.Rate) {
+	for i := range items {
+		items[i].Price = items[i].Price.Convert(rate)
+	}
+}
+
+func GetExchangeRates(c echo.Context) error {
+	rates, err := data.GetExchangeRates()
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching exchange rates.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"base":  money.DefaultCurrency,
+		"rates": rates,
+	})
+}
+
+func SetExchangeRate(c echo.Context) error {
+	var request = struct {
+		Rate string `json:"rate"`
+	}{}
+
+	if err := c.Bind(&request); err != nil {
+		log.Error("Error binding request data. Exchange rate was not saved.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+
+	currency := strings.ToUpper(c.Param("currency"))
+	if len(currency) != 3 || currency == money.DefaultCurrency {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid currency.")
+	}
+
+	rate, err := money.ParseRate(request.Rate, currency)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Rate must be a positive number.")
+	}
+
+	if err := data.SetExchangeRate(rate); err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error saving exchange rate.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Exchange rate saved.")
+}
+
+func DeleteExchangeRate(c echo.Context) error {
+	err := data.DeleteExchangeRate(c.Param("currency"))
+	if errors.Is(err, data.ErrUnknownCurrency) {
+		return util.JsonResponse(c, http.StatusNotFound, "Unknown currency.")
+	}
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error deleting exchange rate.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Exchange rate deleted.")
+}
+
+// SetCurrency saves the currency the user wants to see prices in.
+func SetCurrency(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	var request = struct {
+		Currency string `json:"currency"`
+	}{}
+
+	if err := c.Bind(&request); err != nil {
+		log.Error("Error binding request data. Currency was not saved.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+
+	rate, err := data.GetRate(request.Currency)
+	if errors.Is(err, data.ErrUnknownCurrency) {
+		return util.JsonResponse(c, http.StatusBadRequest, "Unknown currency.")
+	}
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error saving currency.")
+	}
+
+	if err := data.SetUserCurrency(owner.String(), rate.Currency); err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error saving currency.")
+	}
+
+	sess, err := session.Get("session", c)
+	if err != nil {
+		log.Error("Session get error: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error setting session.")
+	}
+	sess.Values["currency"] = rate.Currency
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		log.Error("Session save error: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error setting session.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"currency": rate.Currency,
+	})
+}
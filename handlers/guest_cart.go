@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// WithGuestSession is a middleware that gives a visitor an anonymous session id.
+//
+// The id is stored in the session as "guestID" on the first request and set in the
+// context as "guestID" for the next handler. The guest cart tied to it is merged
+// into the user's cart when the visitor logs in or registers.
+func WithGuestSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve session."})
+		}
+
+		guestID, ok := sess.Values["guestID"].(uuid.UUID)
+		if !ok {
+			guestID = uuid.New()
+			sess.Values["guestID"] = guestID
+			if err := sess.Save(c.Request(), c.Response()); err != nil {
+				log.Error("Session save error: ", err)
+				return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error setting session."})
+			}
+		}
+
+		c.Set("guestID", guestID)
+
+		return next(c)
+	}
+}
+
+func GetGuestCart(c echo.Context) error {
+	guestID := c.Get("guestID").(uuid.UUID)
+
+	cart, err := data.GetGuestCartItems(guestID.String())
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching cart. Please try again later.")
+	}
+
+	rate := displayRate(c)
+	convertCartItems(cart, rate)
+	total, err := data.CartTotal(cart, rate.Currency)
+	if err != nil {
+		log.Error("Error calculating cart total: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching cart. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"cart":  cart,
+		"total": total,
+	})
+}
+
+func AddProductToGuestCart(c echo.Context) error {
+	guestID := c.Get("guestID").(uuid.UUID)
+
+	var cartItem = struct {
+		ID       int `json:"item_id"`
+		Quantity int `json:"quantity"`
+	}{}
+
+	if err := c.Bind(&cartItem); err != nil {
+		log.Error("Error binding request data. Cart item was not added.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	if cartItem.Quantity <= 0 {
+		return util.JsonResponse(c, http.StatusBadRequest, "Quantity must be positive.")
+	}
+
+	if err := data.AddProductToGuestCart(guestID.String(), cartItem.ID, cartItem.Quantity); err != nil {
+		return guestCartError(c, err)
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Product added to cart.")
+}
+
+func SetGuestCartItemQuantity(c echo.Context) error {
+	guestID := c.Get("guestID").(uuid.UUID)
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	var cartItem = struct {
+		Quantity *int `json:"quantity"`
+	}{}
+
+	if err := c.Bind(&cartItem); err != nil {
+		log.Error("Error binding request data. Cart item was not updated.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	if cartItem.Quantity == nil || *cartItem.Quantity < 0 {
+		return util.JsonResponse(c, http.StatusBadRequest, "Quantity must be zero or more.")
+	}
+
+	if err := data.SetGuestCartItemQuantity(guestID.String(), productID, *cartItem.Quantity); err != nil {
+		return guestCartError(c, err)
+	}
+
+	if *cartItem.Quantity == 0 {
+		return util.JsonResponse(c, http.StatusOK, "Product removed from cart.")
+	}
+	return util.JsonResponse(c, http.StatusOK, "Cart updated.")
+}
+
+func ClearGuestCart(c echo.Context) error {
+	guestID := c.Get("guestID").(uuid.UUID)
+
+	if err := data.ClearGuestCart(guestID.String()); err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error clearing cart. Please try again later.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Cart cleared.")
+}
+
+func guestCartError(c echo.Context, err error) error {
+	if errors.Is(err, data.ErrProductNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Product not found.")
+	}
+	log.Error("Database query failed: ", err)
+	return util.JsonResponse(c, http.StatusInternalServerError, "Error updating cart. Please try again later.")
+}
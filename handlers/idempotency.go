@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// WithIdempotency is a middleware that makes a request safe to retry with an Idempotency-Key header.
+//
+// It must be chained after WithAuthentication, as keys are stored per user.
+// The first response for a key is stored and replayed for requests repeating the key.
+// Reusing a key with a different method, path, query or body results in a 409 response.
+// Requests without the header are passed through unchanged.
+// Server errors are not stored, so a failed request can be retried with the same key.
+func WithIdempotency(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get("Idempotency-Key")
+		if key == "" {
+			return next(c)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return util.JsonResponse(c, http.StatusBadRequest, "Idempotency key is too long.")
+		}
+
+		owner, ok := c.Get("userID").(uuid.UUID)
+		if !ok {
+			return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+		}
+
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request().Method + " " + c.Request().URL.Path + "?" + c.Request().URL.RawQuery + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		stored, err := data.ClaimIdempotencyKey(owner.String(), key, requestHash)
+		switch {
+		case errors.Is(err, data.ErrIdempotencyKeyReused):
+			return util.JsonResponse(c, http.StatusConflict, "Idempotency key was already used for a different request.")
+		case errors.Is(err, data.ErrIdempotencyKeyInProgress):
+			return util.JsonResponse(c, http.StatusConflict, "A request with this idempotency key is still in progress.")
+		case err != nil:
+			return util.JsonResponse(c, http.StatusInternalServerError, "Error processing request. Please try again later.")
+		case stored != nil:
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			return c.Blob(stored.StatusCode, stored.ContentType, []byte(stored.Body))
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = recorder
+		// Обработчик упал с паникой — ключ освобождается, паника передаётся дальше
+		defer func() {
+			if r := recover(); r != nil {
+				c.Response().Writer = recorder.ResponseWriter
+				if releaseErr := data.ReleaseIdempotencyKey(owner.String(), key); releaseErr != nil {
+					log.Error("Error releasing idempotency key: ", releaseErr)
+				}
+				panic(r)
+			}
+		}()
+		err = next(c)
+		c.Response().Writer = recorder.ResponseWriter
+
+		// Ответ не записан или сервер упал — ключ освобождается для повторной попытки
+		status := c.Response().Status
+		if err != nil || !c.Response().Committed || status >= http.StatusInternalServerError {
+			if releaseErr := data.ReleaseIdempotencyKey(owner.String(), key); releaseErr != nil {
+				log.Error("Error releasing idempotency key: ", releaseErr)
+			}
+			return err
+		}
+
+		response := data.IdempotentResponse{
+			StatusCode:  status,
+			ContentType: c.Response().Header().Get(echo.HeaderContentType),
+			Body:        recorder.body.String(),
+		}
+		if err := data.SaveIdempotentResponse(owner.String(), key, response); err != nil {
+			log.Error("Error saving idempotent response: ", err)
+		}
+
+		return nil
+	}
+}
+
+// responseRecorder copies the response body while writing it to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
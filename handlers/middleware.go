@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
 )
 
 // WithAuthentication is a middleware function that adds authentication to the request handling chain.
@@ -33,3 +37,31 @@ func WithAuthentication(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
+
+// WithRole returns a middleware that only lets through users with the given role.
+//
+// It must be chained after WithAuthentication, which sets the userID in the context.
+// The role is read from the database on every request, so revoking it takes effect immediately.
+// Users without the role get a 403 response.
+func WithRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("userID").(uuid.UUID)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not authorized or invalid session data"})
+			}
+
+			user, err := data.GetUserById(userID)
+			if err != nil {
+				log.Error("Database query failed: ", err)
+				return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not authorized or invalid session data"})
+			}
+
+			if strings.TrimSpace(user.Role) != role {
+				return c.JSON(http.StatusForbidden, map[string]string{"message": "Access denied."})
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// GetOrder returns an order of the user with its items and status history.
+func GetOrder(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid order id.")
+	}
+
+	order, err := data.GetOrder(owner.String(), id)
+	if errors.Is(err, data.ErrOrderNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Order not found.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching order. Please try again later.")
+	}
+
+	events, err := data.GetOrderEvents(id)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching order. Please try again later.")
+	}
+
+	response := map[string]interface{}{
+		"order":  order,
+		"events": events,
+	}
+	payment, err := data.GetOrderPayment(id)
+	if err == nil {
+		response["payment"] = payment
+	} else if !errors.Is(err, data.ErrPaymentNotFound) {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching order. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// bindOrderFilter reads the order history filters from the query string.
+// Dates are RFC 3339 timestamps or plain dates; a plain "to" date includes the whole day.
+func bindOrderFilter(c echo.Context) (data.OrderFilter, error) {
+	filter := data.OrderFilter{
+		Status: c.QueryParam("status"),
+		Limit:  data.DefaultOrderPageSize,
+	}
+	if filter.Status != "" && !data.IsOrderStatus(filter.Status) {
+		return filter, errors.New("Invalid status.")
+	}
+
+	var err error
+	if raw := c.QueryParam("cursor"); raw != "" {
+		if filter.Cursor, err = strconv.Atoi(raw); err != nil || filter.Cursor <= 0 {
+			return filter, errors.New("Invalid cursor.")
+		}
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil || filter.Limit <= 0 {
+			return filter, errors.New("Invalid limit.")
+		}
+	}
+
+	if raw := c.QueryParam("from"); raw != "" {
+		from, _, err := parseOrderDate(raw)
+		if err != nil {
+			return filter, errors.New("Invalid from date.")
+		}
+		filter.From = &from
+	}
+	if raw := c.QueryParam("to"); raw != "" {
+		to, dateOnly, err := parseOrderDate(raw)
+		if err != nil {
+			return filter, errors.New("Invalid to date.")
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
+	if filter.From != nil && filter.To != nil && !filter.To.After(*filter.From) {
+		return filter, errors.New("The to date must be after the from date.")
+	}
+
+	return filter, nil
+}
+
+func parseOrderDate(raw string) (time.Time, bool, error) {
+	if date, err := time.Parse(time.DateOnly, raw); err == nil {
+		return date, true, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, raw)
+	return timestamp, false, err
+}
+
+// SetOrderStatus moves an order to another status, e.g. from paid to shipped.
+func SetOrderStatus(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid order id.")
+	}
+
+	var request = struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}{}
+
+	if err := c.Bind(&request); err != nil {
+		log.Error("Error binding request data. Order status was not changed.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+
+	err = data.SetOrderStatus(id, request.Status, strings.TrimSpace(request.Note))
+	if err != nil {
+		if err, done := respondOrderError(c, err); done {
+			return err
+		}
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error changing order status.")
+	}
+
+	if request.Status == data.OrderStatusCancelled || request.Status == data.OrderStatusRefunded {
+		if err := refundOrderPayment(c.Request().Context(), id); err != nil {
+			log.Error("Error refunding order: ", err)
+			return util.JsonResponse(c, http.StatusBadGateway, "Order status changed, but the refund failed. It will be retried automatically.")
+		}
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Order status changed.")
+}
+
+func GetOrderEvents(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid order id.")
+	}
+
+	events, err := data.GetOrderEvents(id)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching order events.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"events": events,
+	})
+}
+
+// respondOrderError writes a response for errors of order status changes.
+func respondOrderError(c echo.Context, err error) (error, bool) {
+	switch {
+	case errors.Is(err, data.ErrOrderNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Order not found."), true
+	case errors.Is(err, data.ErrInvalidOrderStatus):
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid order status."), true
+	case errors.Is(err, data.ErrInvalidOrderTransition):
+		return util.JsonResponse(c, http.StatusConflict, "Order status cannot change this way."), true
+	case errors.Is(err, data.ErrOrderNotCancellable):
+		return util.JsonResponse(c, http.StatusConflict, "Only pending or paid orders can be cancelled."), true
+	}
+	return nil, false
+}
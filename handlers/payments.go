@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/payments"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// PaymentWebhook receives signed payment events from the provider.
+//
+// A successful authorization is captured and the order becomes paid; a declined
+// one fails the order. Events may be delivered more than once. Errors are answered
+// with 5xx, so the provider retries the event.
+func PaymentWebhook(c echo.Context) error {
+	payload, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+
+	event, err := payments.Default.VerifyWebhook(payload, c.Request().Header.Get(payments.SignatureHeader))
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		log.Warn("Payment webhook with invalid signature")
+		return util.JsonResponse(c, http.StatusUnauthorized, "Invalid signature.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid event.")
+	}
+
+	if event.Status == payments.EventFailed {
+		err = data.FailPayment(event.PaymentID, event.Reason)
+	} else {
+		err = capturePayment(c.Request().Context(), event.PaymentID)
+	}
+	if errors.Is(err, data.ErrPaymentNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Payment not found.")
+	}
+	if err != nil {
+		log.Error("Error processing payment webhook: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error processing event.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Event processed.")
+}
+
+// capturePayment captures an authorized payment and marks its order paid.
+// The authorization of an order cancelled before capture is voided, and a
+// payment whose order was cancelled during capture is refunded right away.
+func capturePayment(ctx context.Context, paymentID string) error {
+	payment, err := data.AuthorizePayment(paymentID)
+	if err != nil {
+		return err
+	}
+	if payment.Status == data.PaymentFailed && payment.Reference != "" {
+		return payments.Default.Void(ctx, payment.Reference)
+	}
+	if payment.Status != data.PaymentAuthorized {
+		return nil
+	}
+
+	if err := payments.Default.Capture(ctx, payment.Reference, payment.Amount); err != nil {
+		return err
+	}
+
+	paid, err := data.CapturePayment(paymentID)
+	if err != nil || paid {
+		return err
+	}
+	return refundOrderPayment(ctx, payment.OrderID)
+}
+
+// authorizeOrderPayment asks the provider to authorize the payment of a new order.
+// A payment the provider rejects right away fails the order.
+func authorizeOrderPayment(ctx context.Context, payment data.Payment) error {
+	reference, err := payments.Default.Authorize(ctx, payments.AuthorizeRequest{
+		PaymentID: payment.ID,
+		OrderID:   payment.OrderID,
+		Amount:    payment.Amount,
+	})
+	if err != nil {
+		log.Error("Error authorizing payment: ", err)
+		if failErr := data.FailPayment(payment.ID, err.Error()); failErr != nil {
+			log.Error("Error failing payment: ", failErr)
+		}
+		return err
+	}
+
+	return data.SetPaymentReference(payment.ID, payments.Default.Name(), reference)
+}
+
+// refundOrderPayment refunds everything not refunded yet of the order's captured
+// payment. Orders without a captured payment have nothing to refund. The refund
+// is stored before the provider is called and keyed by the order, so a retry
+// never refunds twice.
+func refundOrderPayment(ctx context.Context, orderID int) error {
+	refund, err := data.StartPaymentRefund(orderID, "order-"+strconv.Itoa(orderID), nil)
+	if errors.Is(err, data.ErrPaymentNotFound) || errors.Is(err, data.ErrPaymentNotCaptured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if refund.Status == data.RefundPending && !refund.Amount.IsZero() {
+		if err := payments.Default.Refund(ctx, refund.Reference, refund.Amount, refund.Key); err != nil {
+			return err
+		}
+	}
+	return data.CompletePaymentRefund(refund.Key)
+}
+
+// RetryPendingRefunds finishes refunds whose provider call failed after the
+// order or return was resolved, and refunds cancelled orders whose refund was
+// never started, so the customer is refunded without the request being repeated.
+// The provider deduplicates refunds by their key.
+func RetryPendingRefunds(ctx context.Context) error {
+	refunds, err := data.GetPendingRefunds()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, refund := range refunds {
+		if !refund.Amount.IsZero() {
+			if err := payments.Default.Refund(ctx, refund.Reference, refund.Amount, refund.Key); err != nil {
+				log.Error("Error retrying refund: ", err)
+				failed++
+				continue
+			}
+		}
+
+		if refund.ReturnID != nil {
+			err = data.CompleteReturnRefund(*refund.ReturnID)
+		} else {
+			err = data.CompletePaymentRefund(refund.Key)
+		}
+		if err != nil {
+			failed++
+		}
+	}
+
+	// Заказы, возврат по которым не был даже начат
+	orderIDs, err := data.GetOrdersAwaitingRefund()
+	if err != nil {
+		return err
+	}
+	for _, orderID := range orderIDs {
+		if err := refundOrderPayment(ctx, orderID); err != nil {
+			log.Error("Error refunding order: ", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to finish %d of %d refunds", failed, len(refunds)+len(orderIDs))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// GetProductPrices returns the price history of a product and its scheduled changes.
+func GetProductPrices(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	history, err := data.GetPriceHistory(productID)
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching prices.")
+	}
+
+	changes, err := data.GetPriceChanges(productID)
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching prices.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"history":   history,
+		"scheduled": changes,
+	})
+}
+
+func SchedulePriceChange(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	var change data.PriceChange
+	if err := c.Bind(&change); err != nil {
+		log.Error("Error binding request data. Price change was not scheduled.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	change.ProductID = productID
+
+	switch {
+	case change.Price.IsNegative():
+		return util.JsonResponse(c, http.StatusBadRequest, "Price must not be negative.")
+	case change.StartsAt.IsZero():
+		return util.JsonResponse(c, http.StatusBadRequest, "Start time is required.")
+	case change.EndsAt != nil && !change.EndsAt.After(change.StartsAt):
+		return util.JsonResponse(c, http.StatusBadRequest, "End time must be after start time.")
+	}
+
+	err = data.SchedulePriceChange(&change)
+	switch {
+	case errors.Is(err, data.ErrProductNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Product not found.")
+	case errors.Is(err, data.ErrPriceChangeOverlaps):
+		return util.JsonResponse(c, http.StatusConflict, "Price change overlaps another scheduled change.")
+	case err != nil:
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error scheduling price change.")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"price_change": change,
+	})
+}
+
+func CancelPriceChange(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid price change id.")
+	}
+
+	err = data.CancelPriceChange(id)
+	if errors.Is(err, data.ErrPriceChangeNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "No pending price change with this id.")
+	}
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error cancelling price change.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Price change cancelled.")
+}
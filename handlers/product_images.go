@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/storage"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+const (
+	maxImageSize  = 10 << 20 // 10 MB
+	thumbnailSize = 320
+)
+
+// imageExtensions lists the accepted upload formats.
+var imageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// UploadProductImages stores every file of the "images" form field along with
+// a thumbnail and attaches them to the product.
+func UploadProductImages(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["images"]) == 0 {
+		return util.JsonResponse(c, http.StatusBadRequest, "No images uploaded.")
+	}
+
+	var images []data.ProductImage
+	for _, file := range form.File["images"] {
+		if file.Size > maxImageSize {
+			discardProductImages(c, images)
+			return util.JsonResponse(c, http.StatusBadRequest, fmt.Sprintf("Image %s is larger than 10 MB.", file.Filename))
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			log.Error("Error opening uploaded image: ", err)
+			discardProductImages(c, images)
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid image.")
+		}
+		content, err := io.ReadAll(io.LimitReader(src, maxImageSize))
+		src.Close()
+		if err != nil {
+			log.Error("Error reading uploaded image: ", err)
+			discardProductImages(c, images)
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid image.")
+		}
+
+		image, err := saveProductImage(c, productID, content)
+		if err != nil {
+			// Загрузка выполняется целиком или не выполняется вовсе
+			discardProductImages(c, images)
+		}
+		if errors.Is(err, data.ErrProductNotFound) {
+			return util.JsonResponse(c, http.StatusNotFound, "Product not found.")
+		}
+		if errors.Is(err, errUnsupportedImage) {
+			return util.JsonResponse(c, http.StatusBadRequest, fmt.Sprintf("Image %s must be a JPEG, PNG or GIF.", file.Filename))
+		}
+		if errors.Is(err, util.ErrImageTooLarge) {
+			return util.JsonResponse(c, http.StatusBadRequest, fmt.Sprintf("Image %s has too many pixels.", file.Filename))
+		}
+		if err != nil {
+			log.Error("Error saving product image: ", err)
+			return util.JsonResponse(c, http.StatusInternalServerError, "Error uploading images.")
+		}
+		images = append(images, image)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"images": images,
+	})
+}
+
+func DeleteProductImage(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+	imageID, err := strconv.Atoi(c.Param("imageId"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid image id.")
+	}
+
+	image, err := data.DeleteProductImage(productID, imageID)
+	if errors.Is(err, data.ErrImageNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Image not found.")
+	}
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error deleting image.")
+	}
+
+	// Запись уже удалена, поэтому ошибки удаления файлов только логируются
+	for _, key := range []string{image.Key, image.ThumbnailKey} {
+		if err := storage.Default.Delete(c.Request().Context(), key); err != nil {
+			log.Error("Error deleting stored image: ", err)
+		}
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Image deleted.")
+}
+
+var errUnsupportedImage = errors.New("unsupported image format")
+
+// saveProductImage stores the image and its thumbnail and records them for the product.
+func saveProductImage(c echo.Context, productID int, content []byte) (data.ProductImage, error) {
+	contentType := http.DetectContentType(content)
+	extension, ok := imageExtensions[contentType]
+	if !ok {
+		return data.ProductImage{}, errUnsupportedImage
+	}
+
+	thumbnail, err := util.Thumbnail(content, thumbnailSize)
+	if errors.Is(err, util.ErrImageTooLarge) {
+		return data.ProductImage{}, err
+	}
+	if err != nil {
+		return data.ProductImage{}, errUnsupportedImage
+	}
+
+	name := uuid.New().String()
+	image := data.ProductImage{
+		ProductID:    productID,
+		Key:          fmt.Sprintf("products/%d/%s.%s", productID, name, extension),
+		ThumbnailKey: fmt.Sprintf("products/%d/%s_thumb.jpg", productID, name),
+		ContentType:  contentType,
+	}
+
+	ctx := c.Request().Context()
+	if err := storage.Default.Put(ctx, image.Key, bytes.NewReader(content), int64(len(content)), contentType); err != nil {
+		return image, err
+	}
+	if err := storage.Default.Put(ctx, image.ThumbnailKey, bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/jpeg"); err != nil {
+		storage.Default.Delete(ctx, image.Key)
+		return image, err
+	}
+
+	if err := data.AddProductImage(&image); err != nil {
+		storage.Default.Delete(ctx, image.Key)
+		storage.Default.Delete(ctx, image.ThumbnailKey)
+		return image, err
+	}
+
+	return image, nil
+}
+
+// discardProductImages removes images already saved by a failed upload, so the
+// upload leaves neither records nor stored files behind.
+func discardProductImages(c echo.Context, images []data.ProductImage) {
+	ctx := c.Request().Context()
+	for _, image := range images {
+		if _, err := data.DeleteProductImage(image.ProductID, image.ID); err != nil {
+			log.Error("Error deleting image of failed upload: ", err)
+		}
+		for _, key := range []string{image.Key, image.ThumbnailKey} {
+			if err := storage.Default.Delete(ctx, key); err != nil {
+				log.Error("Error deleting stored image of failed upload: ", err)
+			}
+		}
+	}
+}
@@ -1,49 +1,142 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/money"
 	"github.com/Lexxxzy/go-echo-template/util"
 )
 
 func GetProducts(c echo.Context) error {
-	name := c.QueryParam("title")
+	filter, err := bindProductFilter(c)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	return respondWithProducts(c, filter)
+}
+
+// respondWithProducts writes a page of products matching the filter along
+// with pagination metadata and the requested facet counts.
+func respondWithProducts(c echo.Context, filter data.ProductFilter) error {
+	// Фильтры по цене заданы в валюте отображения, а в базе цены хранятся в базовой
+	rate := displayRate(c)
+	for _, price := range []*money.Money{filter.MinPrice, filter.MaxPrice} {
+		if price != nil {
+			*price = price.Convert(rate.Inverse())
+		}
+	}
 
-	if name == "" {
-		products, err := data.GetAllProducts()
+	page, err := data.ListProducts(filter)
+	if errors.Is(err, data.ErrInvalidCursor) || errors.Is(err, data.ErrInvalidSort) {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid "+err.Error()+".")
+	}
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching products. Please try again later.")
+	}
+
+	pagination := map[string]interface{}{
+		"limit":    filter.Limit,
+		"has_more": page.HasMore,
+	}
+	if page.HasMore {
+		query := c.QueryParams()
+		query.Set("cursor", page.NextCursor)
+		pagination["next_cursor"] = page.NextCursor
+		pagination["next"] = c.Request().URL.Path + "?" + query.Encode()
+	}
+
+	convertProducts(page.Products, rate)
+	response := map[string]interface{}{
+		"products":   page.Products,
+		"pagination": pagination,
+	}
+
+	if facets := c.QueryParam("facets"); facets != "" {
+		counts, err := data.GetProductFacets(filter, strings.Split(facets, ","))
+		if errors.Is(err, data.ErrInvalidFacet) {
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid facets. Supported facets: type, manufacturer, price.")
+		}
 		if err != nil {
 			log.Error("Database query failed: ", err)
 			return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching products. Please try again later.")
 		}
+		for i := range counts.Prices {
+			counts.Prices[i].Min = counts.Prices[i].Min.Convert(rate)
+			if counts.Prices[i].Max != nil {
+				max := counts.Prices[i].Max.Convert(rate)
+				counts.Prices[i].Max = &max
+			}
+		}
+		response["facets"] = counts
+	}
 
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"products": products,
-		})
+	return c.JSON(http.StatusOK, response)
+}
+
+func GetProduct(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
 	}
 
-	products, err := GetProductByName(name)
+	product, err := data.GetProduct(id)
+	if errors.Is(err, data.ErrProductNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Product not found.")
+	}
 	if err != nil {
-		return util.JsonResponse(c, http.StatusInternalServerError, err.Error())
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching product. Please try again later.")
 	}
 
+	product.Price = product.Price.Convert(displayRate(c))
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"products": products,
+		"product": product,
 	})
 }
 
-func GetProductByName(name string) ([]data.Product, error) {
-	products, err := data.SearchProductByName(name)
-	if err != nil {
-		log.Error("Database query failed: ", err)
-		return nil, fmt.Errorf("error fetching products, please try again later")
+// bindProductFilter reads the pagination, sorting and filtering query parameters of GET /products.
+func bindProductFilter(c echo.Context) (data.ProductFilter, error) {
+	filter := data.ProductFilter{
+		Name:         c.QueryParam("title"),
+		TypeName:     c.QueryParam("type"),
+		Manufacturer: c.QueryParam("manufacturer"),
+		Sort:         c.QueryParam("sort"),
+		Cursor:       c.QueryParam("cursor"),
+		Limit:        data.DefaultProductPageSize,
 	}
 
-	return products, nil
+	if limit := c.QueryParam("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return filter, fmt.Errorf("Invalid limit.")
+		}
+		filter.Limit = value
+	}
+
+	for param, target := range map[string]**money.Money{"min_price": &filter.MinPrice, "max_price": &filter.MaxPrice} {
+		raw := c.QueryParam(param)
+		if raw == "" {
+			continue
+		}
+		value, err := money.Parse(raw, money.DefaultCurrency)
+		if err != nil || value.IsNegative() {
+			return filter, fmt.Errorf("Invalid %s.", param)
+		}
+		*target = &value
+	}
+
+	return filter, nil
 }
 
 func GetCart(c echo.Context) error {
@@ -57,14 +150,30 @@ func GetCart(c echo.Context) error {
 		log.Error("Database query failed: ", err)
 		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching cart. Please try again later.")
 	}
-	total := 0.0
-	for _, item := range cart {
-		total += item.Price * float64(item.Quantity)
+	discount, err := data.GetCartDiscount(owner.String())
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching cart. Please try again later.")
+	}
+
+	rate := displayRate(c)
+	convertCartItems(cart, rate)
+	subtotal, err := data.CartTotal(cart, rate.Currency)
+	total := subtotal
+	if err == nil && discount != nil {
+		if err = convertDiscount(discount, rate); err == nil {
+			total, err = subtotal.Sub(discount.Total)
+		}
+	}
+	if err != nil {
+		log.Error("Error calculating cart total: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching cart. Please try again later.")
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"total": total,
-		"cart":  cart,
+		"subtotal": subtotal,
+		"discount": discount,
+		"total":    total,
+		"cart":     cart,
 	})
 }
 
@@ -75,8 +184,9 @@ func AddProductToCart(c echo.Context) error {
 	}
 
 	var cartItem = struct {
-		ID       int `json:"item_id"`
-		Quantity int `json:"quantity"`
+		ID       int  `json:"item_id"`
+		Quantity int  `json:"quantity"`
+		Reserve  bool `json:"reserve"`
 	}{}
 
 	if err := c.Bind(&cartItem); err != nil {
@@ -84,7 +194,13 @@ func AddProductToCart(c echo.Context) error {
 		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
 	}
 
-	if err := data.AddProductToCart(owner.String(), cartItem.ID, cartItem.Quantity); err != nil {
+	if err := data.AddProductToCart(owner.String(), cartItem.ID, cartItem.Quantity, cartItem.Reserve); err != nil {
+		if err, done := respondInsufficientStock(c, err); done {
+			return err
+		}
+		if errors.Is(err, data.ErrProductNotFound) {
+			return util.JsonResponse(c, http.StatusNotFound, "Product not found.")
+		}
 		log.Error("Database query failed: ", err)
 		return util.JsonResponse(c, http.StatusInternalServerError, "Error adding product to cart. Please try again later.")
 	}
@@ -119,20 +235,93 @@ func RemoveProductFromCart(c echo.Context) error {
 	})
 }
 
+// SetCartItemQuantity sets the exact quantity of a product in the cart, zero removes it.
+func SetCartItemQuantity(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	var cartItem = struct {
+		Quantity *int `json:"quantity"`
+		Reserve  bool `json:"reserve"`
+	}{}
+
+	if err := c.Bind(&cartItem); err != nil {
+		log.Error("Error binding request data. Cart item was not updated.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	if cartItem.Quantity == nil || *cartItem.Quantity < 0 {
+		return util.JsonResponse(c, http.StatusBadRequest, "Quantity must be zero or more.")
+	}
+
+	if err := data.SetCartItemQuantity(owner.String(), productID, *cartItem.Quantity, cartItem.Reserve); err != nil {
+		if err, done := respondInsufficientStock(c, err); done {
+			return err
+		}
+		if errors.Is(err, data.ErrProductNotFound) {
+			return util.JsonResponse(c, http.StatusNotFound, "Product not found.")
+		}
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error updating cart. Please try again later.")
+	}
+
+	if *cartItem.Quantity == 0 {
+		return util.JsonResponse(c, http.StatusOK, "Product removed from cart.")
+	}
+	return util.JsonResponse(c, http.StatusOK, "Cart updated.")
+}
+
+func ClearCart(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	if err := data.ClearCart(owner.String()); err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error clearing cart. Please try again later.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, "Cart cleared.")
+}
+
 func GetOrders(c echo.Context) error {
 	owner, ok := c.Get("userID").(uuid.UUID)
 	if !ok {
 		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
 	}
 
-	orders, err := data.GetOrders(owner.String())
+	filter, err := bindOrderFilter(c)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, err.Error())
+	}
+
+	page, err := data.GetOrders(owner.String(), filter)
 	if err != nil {
 		log.Error("Database query failed: ", err)
 		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching orders. Please try again later.")
 	}
 
+	pagination := map[string]interface{}{
+		"limit":    filter.Limit,
+		"has_more": page.HasMore,
+	}
+	if page.HasMore {
+		query := c.QueryParams()
+		query.Set("cursor", strconv.Itoa(page.NextCursor))
+		pagination["next_cursor"] = page.NextCursor
+		pagination["next"] = c.Request().URL.Path + "?" + query.Encode()
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"orders": orders,
+		"orders":     page.Orders,
+		"pagination": pagination,
 	})
 }
 
@@ -142,13 +331,50 @@ func PlaceOrder(c echo.Context) error {
 		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
 	}
 	deliveryAddress := c.FormValue("delivery_address")
+	region := strings.ToUpper(strings.TrimSpace(c.FormValue("region")))
+	if region != "" && !regionPattern.MatchString(region) {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid region.")
+	}
 
-	if err := data.PlaceOrder(owner.String(), deliveryAddress); err != nil {
+	addressID := 0
+	if raw := c.FormValue("address_id"); raw != "" {
+		var err error
+		if addressID, err = strconv.Atoi(raw); err != nil || addressID <= 0 {
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid address id.")
+		}
+	}
+
+	payment, err := data.PlaceOrder(owner.String(), deliveryAddress, addressID, region, displayRate(c))
+	if err != nil {
+		if errors.Is(err, data.ErrAddressNotFound) {
+			return util.JsonResponse(c, http.StatusNotFound, "Address not found.")
+		}
+		if errors.Is(err, data.ErrRegionMismatch) {
+			return util.JsonResponse(c, http.StatusBadRequest, "Region must be in the country of the delivery address.")
+		}
+		if err, done := respondInsufficientStock(c, err); done {
+			return err
+		}
+		if err, done := respondCouponError(c, err); done {
+			return err
+		}
+		if errors.Is(err, data.ErrCartEmpty) {
+			return util.JsonResponse(c, http.StatusBadRequest, "Cart is empty.")
+		}
 		return util.JsonResponse(c, http.StatusInternalServerError, "Error placing order.")
 	}
 
+	if err := authorizeOrderPayment(c.Request().Context(), payment); err != nil {
+		return c.JSON(http.StatusPaymentRequired, map[string]interface{}{
+			"message":  "Payment failed.",
+			"order_id": payment.OrderID,
+		})
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"message": "Order placed successfully.",
+		"message":  "Order placed successfully.",
+		"order_id": payment.OrderID,
+		"payment":  payment,
 	})
 }
 
@@ -168,11 +394,33 @@ func CancelOrder(c echo.Context) error {
 	}
 
 	if err := data.CancelOrder(owner.String(), orderID.ID); err != nil {
+		if err, done := respondOrderError(c, err); done {
+			return err
+		}
 		log.Error("Database query failed: ", err)
 		return util.JsonResponse(c, http.StatusInternalServerError, "Error cancelling order.")
 	}
 
+	if err := refundOrderPayment(c.Request().Context(), orderID.ID); err != nil {
+		log.Error("Error refunding cancelled order: ", err)
+		return util.JsonResponse(c, http.StatusBadGateway, "Order cancelled, but the refund failed. It will be retried automatically.")
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "Order cancelled successfully.",
 	})
 }
+
+// respondInsufficientStock writes a 409 response listing the items that are out of stock,
+// if err is a data.InsufficientStockError.
+func respondInsufficientStock(c echo.Context, err error) (error, bool) {
+	var stockErr *data.InsufficientStockError
+	if !errors.As(err, &stockErr) {
+		return nil, false
+	}
+
+	return c.JSON(http.StatusConflict, map[string]interface{}{
+		"message": "Insufficient stock.",
+		"items":   stockErr.Items,
+	}), true
+}
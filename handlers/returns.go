@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/payments"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+// CreateReturn requests the return of lines of a delivered order.
+func CreateReturn(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid order id.")
+	}
+
+	var request = struct {
+		Reason string            `json:"reason"`
+		Items  []data.ReturnItem `json:"items"`
+	}{}
+
+	if err := c.Bind(&request); err != nil {
+		log.Error("Error binding request data. Return was not requested.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		return util.JsonResponse(c, http.StatusBadRequest, "Reason is required.")
+	}
+
+	ret, err := data.CreateReturn(owner.String(), orderID, request.Reason, request.Items)
+	if err != nil {
+		if err, done := respondReturnError(c, err); done {
+			return err
+		}
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error requesting return. Please try again later.")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"return": ret,
+	})
+}
+
+func GetReturns(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	returns, err := data.GetUserReturns(owner.String())
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching returns. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"returns": returns,
+	})
+}
+
+// GetReturn returns a return of the user with its history.
+func GetReturn(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid return id.")
+	}
+
+	ret, err := data.GetUserReturn(owner.String(), id)
+	if errors.Is(err, data.ErrReturnNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Return not found.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching return. Please try again later.")
+	}
+
+	events, err := data.GetReturnEvents(id)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching return. Please try again later.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"return": ret,
+		"events": events,
+	})
+}
+
+// GetAllReturns lists the returns of all users, optionally filtered by status.
+func GetAllReturns(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch status {
+	case "", data.ReturnRequested, data.ReturnApproved, data.ReturnRejected, data.ReturnRefunded:
+	default:
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid return status.")
+	}
+
+	returns, err := data.GetReturns(status)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching returns.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"returns": returns,
+	})
+}
+
+func GetReturnEvents(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid return id.")
+	}
+
+	ret, err := data.GetReturn(id)
+	if errors.Is(err, data.ErrReturnNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Return not found.")
+	}
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching return events.")
+	}
+
+	events, err := data.GetReturnEvents(id)
+	if err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching return events.")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"return": ret,
+		"events": events,
+	})
+}
+
+// SetReturnStatus approves or rejects a return request. Approval restocks the
+// returned items and refunds them through the payment provider, using the
+// return as the idempotency key. If the refund fails, approving the return
+// again retries it without refunding twice.
+func SetReturnStatus(c echo.Context) error {
+	admin, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid return id.")
+	}
+
+	var request = struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}{}
+
+	if err := c.Bind(&request); err != nil {
+		log.Error("Error binding request data. Return status was not changed.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	note := strings.TrimSpace(request.Note)
+
+	switch request.Status {
+	case data.ReturnRejected:
+		if err := data.RejectReturn(id, admin.String(), note); err != nil {
+			if err, done := respondReturnError(c, err); done {
+				return err
+			}
+			return util.JsonResponse(c, http.StatusInternalServerError, "Error rejecting return.")
+		}
+		return util.JsonResponse(c, http.StatusOK, "Return rejected.")
+	case data.ReturnApproved:
+	default:
+		return util.JsonResponse(c, http.StatusBadRequest, "Status must be approved or rejected.")
+	}
+
+	ret, refund, err := data.ApproveReturn(id, admin.String(), note)
+	if err != nil {
+		if err, done := respondReturnError(c, err); done {
+			return err
+		}
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error approving return.")
+	}
+
+	if refund.Status == data.RefundPending && !refund.Amount.IsZero() {
+		err := payments.Default.Refund(c.Request().Context(), refund.Reference, refund.Amount, refund.Key)
+		if err != nil {
+			log.Error("Error refunding return: ", err)
+			return util.JsonResponse(c, http.StatusBadGateway, "Return approved, but the refund failed. Approve it again to retry.")
+		}
+	}
+	if err := data.CompleteReturnRefund(id); err != nil {
+		return util.JsonResponse(c, http.StatusInternalServerError, "Return refunded, but its status was not updated. Approve it again to finish.")
+	}
+
+	ret.Status = data.ReturnRefunded
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Return approved and refunded.",
+		"return":  ret,
+	})
+}
+
+// respondReturnError writes a response explaining why a return cannot be requested or resolved.
+func respondReturnError(c echo.Context, err error) (error, bool) {
+	switch {
+	case errors.Is(err, data.ErrOrderNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Order not found."), true
+	case errors.Is(err, data.ErrReturnNotFound):
+		return util.JsonResponse(c, http.StatusNotFound, "Return not found."), true
+	case errors.Is(err, data.ErrReturnNotAllowed):
+		return util.JsonResponse(c, http.StatusConflict, "Only delivered orders can be returned."), true
+	case errors.Is(err, data.ErrInvalidReturnItems):
+		return util.JsonResponse(c, http.StatusBadRequest, "Return items must be order lines with quantities not exceeding what is left to return."), true
+	case errors.Is(err, data.ErrReturnResolved):
+		return util.JsonResponse(c, http.StatusConflict, "Return is already resolved."), true
+	case errors.Is(err, data.ErrReturnNotRefundable):
+		return util.JsonResponse(c, http.StatusConflict, "Order has no captured payment to refund. Return was not approved."), true
+	case errors.Is(err, data.ErrRefundExceedsPayment):
+		return util.JsonResponse(c, http.StatusConflict, "Refund exceeds the amount left on the payment. Return was not approved."), true
+	}
+	return nil, false
+}
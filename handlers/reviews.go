@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/db/data"
+	"github.com/Lexxxzy/go-echo-template/util"
+)
+
+const maxReviewLength = 4000
+
+func GetProductReviews(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	cursor, limit := 0, data.DefaultReviewPageSize
+	if raw := c.QueryParam("cursor"); raw != "" {
+		if cursor, err = strconv.Atoi(raw); err != nil || cursor <= 0 {
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid cursor.")
+		}
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+			return util.JsonResponse(c, http.StatusBadRequest, "Invalid limit.")
+		}
+	}
+
+	page, err := data.GetProductReviews(productID, cursor, limit)
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error fetching reviews. Please try again later.")
+	}
+
+	pagination := map[string]interface{}{
+		"limit":    limit,
+		"has_more": page.HasMore,
+	}
+	if page.HasMore {
+		query := c.QueryParams()
+		query.Set("cursor", strconv.Itoa(page.NextCursor))
+		pagination["next_cursor"] = page.NextCursor
+		pagination["next"] = c.Request().URL.Path + "?" + query.Encode()
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"reviews":    page.Reviews,
+		"pagination": pagination,
+	})
+}
+
+func CreateReview(c echo.Context) error {
+	owner, ok := c.Get("userID").(uuid.UUID)
+	if !ok {
+		return util.JsonResponse(c, http.StatusUnauthorized, "Unauthorized.")
+	}
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid product id.")
+	}
+
+	var review data.Review
+	if err := c.Bind(&review); err != nil {
+		log.Error("Error binding request data. Review was not created.")
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid request.")
+	}
+	review.ProductID = productID
+	review.Body = strings.TrimSpace(review.Body)
+
+	if review.Rating < 1 || review.Rating > 5 {
+		return util.JsonResponse(c, http.StatusBadRequest, "Rating must be between 1 and 5.")
+	}
+	if len([]rune(review.Body)) > maxReviewLength {
+		return util.JsonResponse(c, http.StatusBadRequest, "Review text is too long.")
+	}
+
+	err = data.CreateReview(owner.String(), &review)
+	switch {
+	case errors.Is(err, data.ErrReviewNotPurchased):
+		return util.JsonResponse(c, http.StatusForbidden, "You can only review products from your delivered orders.")
+	case errors.Is(err, data.ErrAlreadyReviewed):
+		return util.JsonResponse(c, http.StatusConflict, "You have already reviewed this product.")
+	case err != nil:
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error creating review. Please try again later.")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"review": review,
+	})
+}
+
+func HideReview(c echo.Context) error {
+	return moderateReview(c, func(id int) error { return data.SetReviewHidden(id, true) }, "Review hidden.")
+}
+
+func UnhideReview(c echo.Context) error {
+	return moderateReview(c, func(id int) error { return data.SetReviewHidden(id, false) }, "Review restored.")
+}
+
+func DeleteReview(c echo.Context) error {
+	return moderateReview(c, data.DeleteReview, "Review deleted.")
+}
+
+func moderateReview(c echo.Context, action func(id int) error, message string) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return util.JsonResponse(c, http.StatusBadRequest, "Invalid review id.")
+	}
+
+	err = action(id)
+	if errors.Is(err, data.ErrReviewNotFound) {
+		return util.JsonResponse(c, http.StatusNotFound, "Review not found.")
+	}
+	if err != nil {
+		log.Error("Database query failed: ", err)
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error moderating review.")
+	}
+
+	return util.JsonResponse(c, http.StatusOK, message)
+}
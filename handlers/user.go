@@ -40,13 +40,7 @@ func LoginUser(c echo.Context) error {
 		return util.JsonResponse(c, http.StatusUnauthorized, "Invalid credentials.")
 	}
 
-	if err, done := SetupUserSession(c, user); done {
-		return err
-	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"username": strings.TrimSpace(user.Name),
-	})
+	return SetupUserSession(c, user)
 }
 
 func Register(c echo.Context) error {
@@ -83,31 +77,46 @@ func Register(c echo.Context) error {
 		return util.JsonResponse(c, http.StatusInternalServerError, "Something went wrong.")
 	}
 
-	if err, done := SetupUserSession(c, user); done {
-		return err
-	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"username": strings.TrimSpace(user.Name),
-	})
+	return SetupUserSession(c, user)
 }
 
-func SetupUserSession(c echo.Context, user data.User) (error, bool) {
+// SetupUserSession logs the user in and responds with the user name.
+// A guest cart that cannot be merged stays in the session and is merged on the
+// next login; the response then reports the failure with cart_merged false.
+func SetupUserSession(c echo.Context, user data.User) error {
 	sess, err := session.Get("session", c)
 
 	if err != nil {
 		log.Error("Session get error: ", err)
-		return util.JsonResponse(c, http.StatusInternalServerError, "Error setting session."), true
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error setting session.")
 	}
 
 	sess.Values["authenticated"] = true
 	sess.Values["userID"] = user.ID
+	sess.Values["currency"] = strings.TrimSpace(user.Currency)
+
+	response := map[string]interface{}{
+		"username": strings.TrimSpace(user.Name),
+	}
+
+	// Корзина, собранная до входа, переносится в корзину пользователя
+	if guestID, ok := sess.Values["guestID"].(uuid.UUID); ok {
+		if err := data.MergeGuestCart(guestID.String(), user.ID.String()); err != nil {
+			log.Error("Error merging guest cart: ", err)
+			response["cart_merged"] = false
+			response["message"] = "Your guest cart could not be merged. It will be merged on your next login."
+		} else {
+			delete(sess.Values, "guestID")
+			response["cart_merged"] = true
+		}
+	}
+
 	if err := sess.Save(c.Request(), c.Response()); err != nil {
 		log.Error("Session save error: ", err)
-		return util.JsonResponse(c, http.StatusInternalServerError, "Error setting session."), true
+		return util.JsonResponse(c, http.StatusInternalServerError, "Error setting session.")
 	}
 
-	return nil, false
+	return c.JSON(http.StatusOK, response)
 }
 
 func HealthCheck(c echo.Context) error {
@@ -1,5 +1,10 @@
 package types
 
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
+
 type Config struct {
 	PgPoolInstances []PgPoolInstance `toml:"pg_pool_instance"`
 }
@@ -7,4 +12,6 @@ type Config struct {
 type PgPoolInstance struct {
 	IP   string `toml:"ip"`
 	Port int    `toml:"port"`
+	// Role is either "primary" or "replica". Instances without a role serve both.
+	Role string `toml:"role"`
 }
@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// Every runs job in a background goroutine once per interval.
+// Errors are logged and do not stop subsequent runs.
+func Every(name string, interval time.Duration, job func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := job(); err != nil {
+				log.Errorf("Background job %s failed: %v", name, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,211 @@
+// Package money represents amounts of money exactly, as an integer number of
+// minor units (cents) with a currency code, instead of float64.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of decimal places of every amount.
+// Prices are stored as decimal(10,2), so one minor unit is 0.01.
+const Scale = 2
+
+const unit = 100
+
+// DefaultCurrency is the currency of amounts read from the database.
+var DefaultCurrency = "USD"
+
+var (
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+)
+
+type Money struct {
+	// Amount in minor units, e.g. 1999 for 19.99.
+	Amount   int64
+	Currency string
+}
+
+// New returns an amount of minor units in the currency.
+func New(minor int64, currency string) Money {
+	return Money{Amount: minor, Currency: currency}
+}
+
+// Parse reads a decimal string such as "19.99" without going through float64.
+// More than Scale decimal places are only accepted if the extra digits are zeros.
+func Parse(value string, currency string) (Money, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, ErrInvalidAmount
+	}
+	if len(fraction) > Scale {
+		if strings.Trim(fraction[Scale:], "0") != "" {
+			return Money{}, ErrInvalidAmount
+		}
+		fraction = fraction[:Scale]
+	}
+	fraction += strings.Repeat("0", Scale-len(fraction))
+
+	if whole == "" {
+		whole = "0"
+	}
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return Money{}, ErrInvalidAmount
+		}
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// String formats the amount as a decimal without the currency, e.g. "19.99".
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, Scale, amount%unit)
+}
+
+// Add sums two amounts. An amount without a currency takes the currency of the other.
+// Amounts in different currencies have to be converted first; adding them
+// returns ErrCurrencyMismatch.
+func (m Money) Add(other Money) (Money, error) {
+	currency := m.Currency
+	if currency == "" {
+		currency = other.Currency
+	} else if other.Currency != "" && other.Currency != currency {
+		return m, fmt.Errorf("%w: cannot add %s to %s", ErrCurrencyMismatch, other.Currency, currency)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: currency}, nil
+}
+
+// Sub subtracts an amount in the same currency, like Add.
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(other.Neg())
+}
+
+// Neg returns the amount with the opposite sign.
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Sum adds up the amounts, which must all be in the same currency.
+func Sum(amounts ...Money) (Money, error) {
+	var total Money
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Mul multiplies the amount by a quantity.
+func (m Money) Mul(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON writes the amount as a decimal string, so clients never see float rounding.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.String(), Currency: m.Currency})
+}
+
+// UnmarshalJSON accepts an object with amount and currency, a decimal string or a number.
+// The number is parsed from its text, not through float64.
+func (m *Money) UnmarshalJSON(raw []byte) error {
+	text := strings.TrimSpace(string(raw))
+	if text == "null" {
+		return nil
+	}
+
+	var value jsonMoney
+	switch {
+	case strings.HasPrefix(text, "{"):
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+	case strings.HasPrefix(text, `"`):
+		if err := json.Unmarshal(raw, &value.Amount); err != nil {
+			return err
+		}
+	default:
+		value.Amount = text
+	}
+
+	currency := strings.ToUpper(value.Currency)
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	parsed, err := Parse(value.Amount, currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a numeric column in DefaultCurrency.
+func (m *Money) Scan(src interface{}) error {
+	var parsed Money
+	var err error
+
+	switch value := src.(type) {
+	case nil:
+		parsed = Money{}
+	case []byte:
+		parsed, err = Parse(string(value), DefaultCurrency)
+	case string:
+		parsed, err = Parse(value, DefaultCurrency)
+	case int64:
+		parsed = Money{Amount: value * unit, Currency: DefaultCurrency}
+	case float64:
+		parsed, err = Parse(strconv.FormatFloat(value, 'f', Scale, 64), DefaultCurrency)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+
+	if err != nil {
+		return fmt.Errorf("money: cannot scan %v: %w", src, err)
+	}
+	*m = parsed
+	return nil
+}
+
+// Value writes the amount as a decimal string for numeric columns.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,154 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{"19.99", 1999, false},
+		{"19.9", 1990, false},
+		{"19", 1900, false},
+		{"-0.05", -5, false},
+		{"1.500", 150, false},
+		{"1.505", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.value, "USD")
+		if test.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", test.value, err)
+			continue
+		}
+		if got.Amount != test.want || got.Currency != "USD" {
+			t.Errorf("Parse(%q) = %+v, want %d USD", test.value, got, test.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		amount int64
+		want   string
+	}{
+		{1999, "19.99"},
+		{5, "0.05"},
+		{0, "0.00"},
+		{-150, "-1.50"},
+	}
+
+	for _, test := range tests {
+		if got := New(test.amount, "USD").String(); got != test.want {
+			t.Errorf("New(%d).String() = %q, want %q", test.amount, got, test.want)
+		}
+	}
+}
+
+func TestAddSub(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     Money
+		add, sub Money
+	}{
+		{"same currency", New(1000, "USD"), New(250, "USD"), New(1250, "USD"), New(750, "USD")},
+		{"left without currency", New(0, ""), New(250, "EUR"), New(250, "EUR"), New(-250, "EUR")},
+		{"right without currency", New(1000, "EUR"), Money{}, New(1000, "EUR"), New(1000, "EUR")},
+	}
+
+	for _, test := range tests {
+		add, err := test.a.Add(test.b)
+		if err != nil || add != test.add {
+			t.Errorf("%s: Add = %+v, %v, want %+v", test.name, add, err, test.add)
+		}
+		sub, err := test.a.Sub(test.b)
+		if err != nil || sub != test.sub {
+			t.Errorf("%s: Sub = %+v, %v, want %+v", test.name, sub, err, test.sub)
+		}
+	}
+}
+
+func TestCurrencyMismatch(t *testing.T) {
+	usd, eur := New(1000, "USD"), New(1000, "EUR")
+
+	if _, err := usd.Add(eur); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add of USD and EUR returned %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := usd.Sub(eur); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Sub of USD and EUR returned %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := Sum(usd, usd, eur); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Sum of USD and EUR returned %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestSum(t *testing.T) {
+	got, err := Sum(New(1000, "USD"), New(200, "USD").Neg(), New(50, "USD"))
+	if err != nil || got != New(850, "USD") {
+		t.Errorf("Sum = %+v, %v, want 8.50 USD", got, err)
+	}
+
+	if got, err := Sum(); err != nil || !got.IsZero() {
+		t.Errorf("Sum() = %+v, %v, want zero", got, err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		rate   string
+		want   int64
+	}{
+		{"identity", 1999, "1", 1999},
+		{"rounds down below half", 1001, "0.92", 921},   // 920.92
+		{"rounds half away from zero", 50, "0.25", 13},  // 12.5
+		{"rounds negative half away", -50, "0.25", -13}, // -12.5
+		{"rounds up above half", 1999, "0.9", 1799},     // 1799.1
+		{"many decimals", 10000, "1.23456789", 12346},   // 12345.6789
+	}
+
+	for _, test := range tests {
+		rate, err := ParseRate(test.rate, "eur")
+		if err != nil {
+			t.Fatalf("%s: ParseRate returned %v", test.name, err)
+		}
+		got := New(test.amount, "USD").Convert(rate)
+		if got.Amount != test.want || got.Currency != "EUR" {
+			t.Errorf("%s: Convert = %+v, want %d EUR", test.name, got, test.want)
+		}
+	}
+
+	if got := New(1999, "USD").Convert(Rate{}); got != New(1999, "USD") {
+		t.Errorf("Convert without a rate = %+v, want the amount unchanged", got)
+	}
+}
+
+func TestRateInverse(t *testing.T) {
+	rate := Rate{Currency: "EUR", Value: big.NewRat(4, 5)}
+	back := New(1000, "USD").Convert(rate).Convert(rate.Inverse())
+	if back != New(1000, DefaultCurrency) {
+		t.Errorf("Converting there and back = %+v, want 10.00 %s", back, DefaultCurrency)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for _, value := range []string{"0", "-1", "abc", ""} {
+		if _, err := ParseRate(value, "EUR"); err == nil {
+			t.Errorf("ParseRate(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// RateScale is the number of decimal places exchange rates are stored with.
+const RateScale = 8
+
+// Rate converts amounts from the base currency into Currency.
+// Value is the number of Currency units per one unit of the base currency.
+type Rate struct {
+	Currency string
+	Value    *big.Rat
+}
+
+// BaseRate converts the base currency into itself.
+func BaseRate() Rate {
+	return Rate{Currency: DefaultCurrency, Value: big.NewRat(1, 1)}
+}
+
+// ParseRate reads a positive decimal rate such as "0.92".
+func ParseRate(value string, currency string) (Rate, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok || rat.Sign() <= 0 {
+		return Rate{}, fmt.Errorf("invalid exchange rate %q", value)
+	}
+	return Rate{Currency: strings.ToUpper(currency), Value: rat}, nil
+}
+
+func (r Rate) String() string {
+	if r.Value == nil {
+		return "1"
+	}
+	text := r.Value.FloatString(RateScale)
+	text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	return text
+}
+
+// Inverse converts back from Currency into the base currency.
+func (r Rate) Inverse() Rate {
+	if r.Value == nil {
+		return BaseRate()
+	}
+	return Rate{Currency: DefaultCurrency, Value: new(big.Rat).Inv(r.Value)}
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"currency": r.Currency, "rate": r.String()})
+}
+
+// Convert multiplies the amount by the rate, rounding half away from zero to a minor unit.
+func (m Money) Convert(rate Rate) Money {
+	if rate.Value == nil {
+		return m
+	}
+
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate.Value)
+	quotient, remainder := new(big.Int).QuoRem(product.Num(), product.Denom(), new(big.Int))
+
+	// Round away from zero when the remainder is at least half of the denominator
+	remainder.Abs(remainder).Mul(remainder, big.NewInt(2))
+	if remainder.Cmp(product.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(product.Sign())))
+	}
+
+	return Money{Amount: quotient.Int64(), Currency: rate.Currency}
+}
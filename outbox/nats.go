@@ -0,0 +1,157 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS publishes every message to the subject prefix + topic of a NATS server.
+// It speaks the core text protocol and waits for the server to answer a PING
+// after each PUB, so a returned nil means the server has accepted the message.
+// TLS is not supported.
+type NATS struct {
+	addr   string
+	user   string
+	pass   string
+	prefix string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewNATS(rawURL string, prefix string) (*NATS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing NATS_URL: %s", err.Error())
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	n := &NATS{addr: addr, prefix: prefix}
+	if u.User != nil {
+		n.user = u.User.Username()
+		n.pass, _ = u.User.Password()
+	}
+	return n, nil
+}
+
+func (n *NATS) Name() string {
+	return "nats"
+}
+
+func (n *NATS) Publish(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.publish(ctx, n.prefix+message.Topic, body); err != nil {
+		// Drop the connection; the next publish reconnects.
+		if n.conn != nil {
+			n.conn.Close()
+			n.conn = nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (n *NATS) publish(ctx context.Context, subject string, body []byte) error {
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	if err := n.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\nPING\r\n", subject, len(body), body); err != nil {
+		return err
+	}
+	return n.waitPong()
+}
+
+func (n *NATS) connect(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting from NATS: %q", strings.TrimSpace(line))
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err == nil && info.TLSRequired {
+		conn.Close()
+		return errors.New("NATS server requires TLS, which is not supported")
+	}
+
+	options, _ := json.Marshal(map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "go-echo-template-outbox",
+		"user":     n.user,
+		"pass":     n.pass,
+	})
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", options); err != nil {
+		conn.Close()
+		return err
+	}
+
+	n.conn, n.reader = conn, reader
+	return nil
+}
+
+// waitPong reads until the answer to our PING, answering PINGs of the server.
+func (n *NATS) waitPong() error {
+	for {
+		line, err := n.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
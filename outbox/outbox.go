@@ -0,0 +1,116 @@
+// Package outbox publishes domain events from the outbox table to pluggable sinks.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is an event read from the outbox. Delivery is at least once, so
+// consumers should use ID to skip events they have already handled.
+type Message struct {
+	ID        int64           `json:"id"`
+	Topic     string          `json:"topic"`
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Sink delivers messages to a downstream system.
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, message Message) error
+}
+
+// Multi publishes every message to all of its sinks. A message that fails in
+// any sink is retried in all of them.
+type Multi []Sink
+
+func (m Multi) Name() string {
+	names := make([]string, len(m))
+	for i, sink := range m {
+		names[i] = sink.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m Multi) Publish(ctx context.Context, message Message) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Publish(ctx, message); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var Default Sink
+
+// Init configures Default from the environment.
+//
+// OUTBOX_SINKS is a comma-separated list of sinks: "stdout", "file", "webhook"
+// and "nats". It defaults to "nats" if NATS_URL is set. Without sinks Default
+// is nil and events stay in the outbox until a sink is configured.
+// The file sink appends JSON lines to OUTBOX_FILE. The webhook sink POSTs every
+// message to OUTBOX_WEBHOOK_URL, signed with OUTBOX_WEBHOOK_SECRET if it is set.
+// The nats sink publishes to NATS_URL under the subject NATS_SUBJECT_PREFIX
+// followed by the topic.
+func Init() error {
+	names := os.Getenv("OUTBOX_SINKS")
+	if names == "" && os.Getenv("NATS_URL") != "" {
+		names = "nats"
+	}
+
+	var sinks Multi
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+			continue
+		case "stdout":
+			sinks = append(sinks, NewWriter("stdout", os.Stdout))
+		case "file":
+			path := os.Getenv("OUTBOX_FILE")
+			if path == "" {
+				return errors.New("OUTBOX_FILE is required for the file sink")
+			}
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, NewWriter("file", file))
+		case "webhook":
+			url := os.Getenv("OUTBOX_WEBHOOK_URL")
+			if url == "" {
+				return errors.New("OUTBOX_WEBHOOK_URL is required for the webhook sink")
+			}
+			sinks = append(sinks, NewWebhook(url, os.Getenv("OUTBOX_WEBHOOK_SECRET")))
+		case "nats":
+			url := os.Getenv("NATS_URL")
+			if url == "" {
+				return errors.New("NATS_URL is required for the nats sink")
+			}
+			prefix := os.Getenv("NATS_SUBJECT_PREFIX")
+			if prefix == "" {
+				prefix = "shop."
+			}
+			nats, err := NewNATS(url, prefix)
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, nats)
+		default:
+			return fmt.Errorf("unknown outbox sink %q", name)
+		}
+	}
+
+	Default = nil
+	if len(sinks) > 0 {
+		Default = sinks
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the webhook body.
+const SignatureHeader = "X-Outbox-Signature"
+
+// Webhook POSTs every message as JSON to a URL. Any status other than 2xx is
+// a failure and the message is retried.
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhook(url string, secret string) *Webhook {
+	return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Publish(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Outbox-Id", strconv.FormatInt(message.ID, 10))
+	req.Header.Set("X-Outbox-Topic", message.Topic)
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Writer writes every message as a line of JSON, e.g. to stdout or a file.
+type Writer struct {
+	name string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(name string, w io.Writer) *Writer {
+	return &Writer{name: name, w: w}
+}
+
+func (w *Writer) Name() string {
+	return w.name
+}
+
+func (w *Writer) Publish(ctx context.Context, message Message) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,163 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/gommon/log"
+
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+var ErrUnknownPayment = errors.New("unknown payment")
+
+// Mock is an in-process provider for development. It keeps payments in memory
+// and reports every authorization to the webhook URL with a signed event.
+type Mock struct {
+	secret     string
+	webhookURL string
+	delay      time.Duration
+	outcome    string
+
+	mu       sync.Mutex
+	payments map[string]*mockPayment
+	refunds  map[string]bool
+}
+
+type mockPayment struct {
+	authorized money.Money
+	captured   money.Money
+	refunded   money.Money
+	voided     bool
+}
+
+func NewMock(secret string, webhookURL string, delay time.Duration, outcome string) *Mock {
+	return &Mock{
+		secret:     secret,
+		webhookURL: webhookURL,
+		delay:      delay,
+		outcome:    outcome,
+		payments:   make(map[string]*mockPayment),
+		refunds:    make(map[string]bool),
+	}
+}
+
+func (m *Mock) Name() string {
+	return "mock"
+}
+
+func (m *Mock) Authorize(ctx context.Context, req AuthorizeRequest) (string, error) {
+	if req.Amount.IsNegative() {
+		return "", errors.New("amount must not be negative")
+	}
+
+	reference := "mock_" + uuid.NewString()
+	m.mu.Lock()
+	m.payments[reference] = &mockPayment{authorized: req.Amount}
+	m.mu.Unlock()
+
+	if m.webhookURL != "" {
+		event := Event{PaymentID: req.PaymentID, Status: m.outcome}
+		if m.outcome == EventFailed {
+			event.Reason = "declined by mock provider"
+		}
+		go m.sendWebhook(event)
+	}
+
+	return reference, nil
+}
+
+func (m *Mock) Capture(ctx context.Context, reference string, amount money.Money) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if payment.voided {
+		return errors.New("authorization was voided")
+	}
+	if amount.Amount > payment.authorized.Amount {
+		return errors.New("capture exceeds the authorized amount")
+	}
+	payment.captured = amount
+	return nil
+}
+
+func (m *Mock) Refund(ctx context.Context, reference string, amount money.Money, idempotencyKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if m.refunds[idempotencyKey] {
+		return nil
+	}
+	refunded, err := payment.refunded.Add(amount)
+	if err != nil {
+		return err
+	}
+	if refunded.Amount > payment.captured.Amount {
+		return errors.New("refund exceeds the captured amount")
+	}
+	payment.refunded = refunded
+	m.refunds[idempotencyKey] = true
+	return nil
+}
+
+func (m *Mock) Void(ctx context.Context, reference string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payment, ok := m.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if !payment.captured.IsZero() {
+		return errors.New("captured payment cannot be voided")
+	}
+	payment.voided = true
+	return nil
+}
+
+func (m *Mock) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	return verifySignature(m.secret, payload, signature)
+}
+
+// sendWebhook posts the event after the delay, retrying a few times like a real gateway.
+func (m *Mock) sendWebhook(event Event) {
+	payload, _ := json.Marshal(event)
+	signature := Sign(m.secret, payload)
+
+	delay := m.delay
+	for attempt := 1; attempt <= 5; attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+
+		req, err := http.NewRequest(http.MethodPost, m.webhookURL, bytes.NewReader(payload))
+		if err != nil {
+			log.Error("Error creating payment webhook: ", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(SignatureHeader, signature)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				return
+			}
+		}
+		log.Warnf("Payment webhook attempt %d for %s failed", attempt, event.PaymentID)
+	}
+}
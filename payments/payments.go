@@ -0,0 +1,123 @@
+// Package payments charges orders through a pluggable payment provider.
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+// Outcomes of an authorization reported by a webhook.
+const (
+	EventSucceeded = "succeeded"
+	EventFailed    = "failed"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the webhook body.
+const SignatureHeader = "X-Payment-Signature"
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidEvent     = errors.New("invalid webhook event")
+)
+
+// AuthorizeRequest asks the provider to hold Amount for an order.
+// PaymentID is our reference and is sent back in webhook events.
+type AuthorizeRequest struct {
+	PaymentID string
+	OrderID   int
+	Amount    money.Money
+}
+
+// Event is a verified webhook notification about a payment.
+type Event struct {
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Provider is a payment gateway. Authorization is asynchronous: its outcome is
+// delivered later as a webhook event, which may arrive more than once.
+type Provider interface {
+	// Name is stored with the payment.
+	Name() string
+	// Authorize starts holding the amount and returns the provider's reference.
+	Authorize(ctx context.Context, req AuthorizeRequest) (string, error)
+	Capture(ctx context.Context, reference string, amount money.Money) error
+	// Refund returns the amount to the customer. Retrying with the same
+	// idempotency key does not refund again.
+	Refund(ctx context.Context, reference string, amount money.Money, idempotencyKey string) error
+	// Void releases an authorization that will never be captured.
+	Void(ctx context.Context, reference string) error
+	// VerifyWebhook checks the signature of a webhook body and decodes its event.
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+var Default Provider
+
+// Init configures Default from the environment.
+//
+// PAYMENT_PROVIDER selects the provider; only "mock" (default) is available.
+// Webhooks are signed with PAYMENT_WEBHOOK_SECRET, which is required: without it
+// anyone could forge payment events. The mock provider sends its
+// events to PAYMENT_MOCK_WEBHOOK_URL after PAYMENT_MOCK_DELAY, declining every
+// authorization if PAYMENT_MOCK_OUTCOME is "failed".
+func Init() error {
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		return errors.New("PAYMENT_WEBHOOK_SECRET is required")
+	}
+
+	switch provider := os.Getenv("PAYMENT_PROVIDER"); provider {
+	case "", "mock":
+		delay := time.Second
+		if raw := os.Getenv("PAYMENT_MOCK_DELAY"); raw != "" {
+			var err error
+			if delay, err = time.ParseDuration(raw); err != nil {
+				return fmt.Errorf("error parsing PAYMENT_MOCK_DELAY: %s", err.Error())
+			}
+		}
+		outcome := os.Getenv("PAYMENT_MOCK_OUTCOME")
+		if outcome == "" {
+			outcome = EventSucceeded
+		}
+		if outcome != EventSucceeded && outcome != EventFailed {
+			return fmt.Errorf("unknown PAYMENT_MOCK_OUTCOME %q", outcome)
+		}
+		Default = NewMock(secret, os.Getenv("PAYMENT_MOCK_WEBHOOK_URL"), delay, outcome)
+	default:
+		return fmt.Errorf("unknown payment provider %q", provider)
+	}
+
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of the payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks the signature and decodes the event of a webhook body.
+func verifySignature(secret string, payload []byte, signature string) (Event, error) {
+	var event Event
+	if !hmac.Equal([]byte(Sign(secret, payload)), []byte(signature)) {
+		return event, ErrInvalidSignature
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.PaymentID == "" {
+		return event, ErrInvalidEvent
+	}
+	if event.Status != EventSucceeded && event.Status != EventFailed {
+		return event, ErrInvalidEvent
+	}
+	return event, nil
+}
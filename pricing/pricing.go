@@ -0,0 +1,107 @@
+// Package pricing computes checkout totals by running a quote through a
+// pipeline of steps: subtotal, shipping and tax.
+package pricing
+
+import (
+	"math/big"
+
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+// Line is a cart line as seen by the pricing steps.
+type Line struct {
+	ProductID   int
+	Price       money.Money
+	Quantity    int
+	WeightGrams int
+}
+
+// Quote is the price breakdown of a checkout. Amounts are in the base currency.
+// Discount is set by the caller before the pipeline runs.
+type Quote struct {
+	Lines    []Line      `json:"-"`
+	Region   string      `json:"region"`
+	TaxRate  *big.Rat    `json:"-"` // percent, nil means no tax
+	Subtotal money.Money `json:"subtotal"`
+	Discount money.Money `json:"discount"`
+	Shipping money.Money `json:"shipping"`
+	Tax      money.Money `json:"tax"`
+	Total    money.Money `json:"total"`
+}
+
+// Step fills in part of a quote.
+type Step interface {
+	Apply(q *Quote) error
+}
+
+// StepFunc adapts a function to Step.
+type StepFunc func(q *Quote) error
+
+func (f StepFunc) Apply(q *Quote) error {
+	return f(q)
+}
+
+type Pipeline []Step
+
+// Default is the pipeline used for checkout, configured by Init.
+var Default = Pipeline{Subtotal{}, FlatShipping{}, Tax{}}
+
+// Run applies every step in order and sums the total.
+func (p Pipeline) Run(q *Quote) error {
+	zero := money.New(0, money.DefaultCurrency)
+	q.Subtotal, q.Shipping, q.Tax = zero, zero, zero
+	if q.Discount.Currency == "" {
+		q.Discount = zero
+	}
+
+	for _, step := range p {
+		if err := step.Apply(q); err != nil {
+			return err
+		}
+	}
+
+	total, err := money.Sum(q.Subtotal, q.Discount.Neg(), q.Shipping, q.Tax)
+	if err != nil {
+		return err
+	}
+	q.Total = total
+	return nil
+}
+
+// Subtotal sums the price of every line times its quantity.
+type Subtotal struct{}
+
+func (Subtotal) Apply(q *Quote) error {
+	for _, line := range q.Lines {
+		subtotal, err := q.Subtotal.Add(line.Price.Mul(line.Quantity))
+		if err != nil {
+			return err
+		}
+		q.Subtotal = subtotal
+	}
+	return nil
+}
+
+// Tax charges TaxRate percent of the discounted subtotal and shipping.
+type Tax struct{}
+
+func (Tax) Apply(q *Quote) error {
+	if q.TaxRate == nil {
+		return nil
+	}
+	taxable, err := money.Sum(q.Subtotal, q.Discount.Neg(), q.Shipping)
+	if err != nil {
+		return err
+	}
+	if taxable.Amount <= 0 {
+		return nil
+	}
+	q.Tax = percentOf(taxable, q.TaxRate)
+	return nil
+}
+
+// percentOf returns percent of the amount, rounded half away from zero to a minor unit.
+func percentOf(m money.Money, percent *big.Rat) money.Money {
+	rate := money.Rate{Currency: m.Currency, Value: new(big.Rat).Quo(percent, big.NewRat(100, 1))}
+	return m.Convert(rate)
+}
@@ -0,0 +1,96 @@
+package pricing
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+func usd(minor int64) money.Money {
+	return money.New(minor, money.DefaultCurrency)
+}
+
+func TestPipelineRun(t *testing.T) {
+	lines := []Line{
+		{ProductID: 1, Price: usd(1999), Quantity: 2, WeightGrams: 400},
+		{ProductID: 2, Price: usd(500), Quantity: 1, WeightGrams: 1500},
+	}
+
+	tests := []struct {
+		name     string
+		pipeline Pipeline
+		discount money.Money
+		taxRate  *big.Rat
+		want     Quote
+	}{
+		{
+			name:     "subtotal only",
+			pipeline: Pipeline{Subtotal{}},
+			want:     Quote{Subtotal: usd(4498), Total: usd(4498)},
+		},
+		{
+			name:     "discount, flat shipping and tax on the discounted subtotal and shipping",
+			pipeline: Pipeline{Subtotal{}, FlatShipping{Amount: usd(499)}, Tax{}},
+			discount: usd(498),
+			taxRate:  big.NewRat(10, 1),
+			// (44.98 - 4.98 + 4.99) * 10% = 4.499
+			want: Quote{Subtotal: usd(4498), Discount: usd(498), Shipping: usd(499), Tax: usd(450), Total: usd(4949)},
+		},
+		{
+			name:     "weight shipping charges every started kilogram",
+			pipeline: Pipeline{Subtotal{}, WeightShipping{Base: usd(300), PerKg: usd(150)}},
+			// 2.3 kg are charged as 3 kg
+			want: Quote{Subtotal: usd(4498), Shipping: usd(750), Total: usd(5248)},
+		},
+		{
+			name:     "free shipping over the threshold",
+			pipeline: Pipeline{Subtotal{}, FreeOver{Threshold: usd(4000), Rule: FlatShipping{Amount: usd(499)}}},
+			want:     Quote{Subtotal: usd(4498), Total: usd(4498)},
+		},
+		{
+			name:     "discount drops the subtotal below the free shipping threshold",
+			pipeline: Pipeline{Subtotal{}, FreeOver{Threshold: usd(4000), Rule: FlatShipping{Amount: usd(499)}}},
+			discount: usd(1000),
+			want:     Quote{Subtotal: usd(4498), Discount: usd(1000), Shipping: usd(499), Total: usd(3997)},
+		},
+		{
+			name:     "no tax when the discount covers everything",
+			pipeline: Pipeline{Subtotal{}, Tax{}},
+			discount: usd(4498),
+			taxRate:  big.NewRat(20, 1),
+			want:     Quote{Subtotal: usd(4498), Discount: usd(4498), Total: usd(0)},
+		},
+	}
+
+	for _, test := range tests {
+		quote := Quote{Lines: lines, Discount: test.discount, TaxRate: test.taxRate}
+		if err := test.pipeline.Run(&quote); err != nil {
+			t.Errorf("%s: Run returned %v", test.name, err)
+			continue
+		}
+
+		want := test.want
+		for _, part := range []struct {
+			name      string
+			got, want money.Money
+		}{
+			{"subtotal", quote.Subtotal, want.Subtotal},
+			{"discount", quote.Discount, want.Discount},
+			{"shipping", quote.Shipping, want.Shipping},
+			{"tax", quote.Tax, want.Tax},
+			{"total", quote.Total, want.Total},
+		} {
+			if part.got.Amount != part.want.Amount {
+				t.Errorf("%s: %s = %s, want %s", test.name, part.name, part.got, part.want)
+			}
+		}
+	}
+}
+
+func TestPipelineRunCurrencyMismatch(t *testing.T) {
+	quote := Quote{Lines: []Line{{ProductID: 1, Price: money.New(1000, "EUR"), Quantity: 1}}}
+	if err := (Pipeline{Subtotal{}}).Run(&quote); err == nil {
+		t.Errorf("Run with lines in another currency succeeded, want an error")
+	}
+}
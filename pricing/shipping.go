@@ -0,0 +1,113 @@
+package pricing
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/Lexxxzy/go-echo-template/money"
+)
+
+// FlatShipping charges the same amount for every order.
+type FlatShipping struct {
+	Amount money.Money
+}
+
+func (s FlatShipping) Apply(q *Quote) error {
+	shipping, err := q.Shipping.Add(s.Amount)
+	if err != nil {
+		return err
+	}
+	q.Shipping = shipping
+	return nil
+}
+
+// WeightShipping charges Base plus PerKg for every started kilogram.
+type WeightShipping struct {
+	Base  money.Money
+	PerKg money.Money
+}
+
+func (s WeightShipping) Apply(q *Quote) error {
+	grams := 0
+	for _, line := range q.Lines {
+		grams += line.WeightGrams * line.Quantity
+	}
+	kilograms := (grams + 999) / 1000
+	shipping, err := money.Sum(q.Shipping, s.Base, s.PerKg.Mul(kilograms))
+	if err != nil {
+		return err
+	}
+	q.Shipping = shipping
+	return nil
+}
+
+// FreeOver makes shipping free once the discounted subtotal reaches Threshold
+// and uses Rule below it.
+type FreeOver struct {
+	Threshold money.Money
+	Rule      Step
+}
+
+func (s FreeOver) Apply(q *Quote) error {
+	discounted, err := q.Subtotal.Sub(q.Discount)
+	if err != nil {
+		return err
+	}
+	if discounted.Amount >= s.Threshold.Amount {
+		return nil
+	}
+	return s.Rule.Apply(q)
+}
+
+// Init configures the shipping step of Default from the environment.
+//
+// SHIPPING_RULE selects the rule: "flat" (default) charges SHIPPING_FLAT,
+// "weight" charges SHIPPING_BASE plus SHIPPING_PER_KG for every started kilogram.
+// If SHIPPING_FREE_OVER is set, orders reaching that amount ship for free.
+func Init() error {
+	var shipping Step
+	switch rule := os.Getenv("SHIPPING_RULE"); rule {
+	case "", "flat":
+		amount, err := envAmount("SHIPPING_FLAT")
+		if err != nil {
+			return err
+		}
+		shipping = FlatShipping{Amount: amount}
+	case "weight":
+		base, err := envAmount("SHIPPING_BASE")
+		if err != nil {
+			return err
+		}
+		perKg, err := envAmount("SHIPPING_PER_KG")
+		if err != nil {
+			return err
+		}
+		shipping = WeightShipping{Base: base, PerKg: perKg}
+	default:
+		return fmt.Errorf("unknown shipping rule %q", rule)
+	}
+
+	if os.Getenv("SHIPPING_FREE_OVER") != "" {
+		threshold, err := envAmount("SHIPPING_FREE_OVER")
+		if err != nil {
+			return err
+		}
+		shipping = FreeOver{Threshold: threshold, Rule: shipping}
+	}
+
+	Default = Pipeline{Subtotal{}, shipping, Tax{}}
+	return nil
+}
+
+func envAmount(name string) (money.Money, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return money.New(0, money.DefaultCurrency), nil
+	}
+	amount, err := money.Parse(value, money.DefaultCurrency)
+	if err != nil || amount.IsNegative() {
+		return amount, fmt.Errorf("invalid %s %s", name, strconv.Quote(value))
+	}
+	return amount, nil
+}
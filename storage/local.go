@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects as files under a directory.
+type Local struct {
+	Dir       string
+	PublicURL string
+}
+
+func NewLocal(dir string, publicURL string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir, PublicURL: strings.TrimSuffix(publicURL, "/")}, nil
+}
+
+func (local *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return file.Close()
+}
+
+func (local *Local) Delete(ctx context.Context, key string) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (local *Local) URL(key string) string {
+	return local.PublicURL + "/" + key
+}
+
+// path resolves the key inside Dir, rejecting keys that would escape it.
+func (local *Local) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" {
+		return "", errors.New("empty storage key")
+	}
+	return filepath.Join(local.Dir, filepath.FromSlash(cleaned)), nil
+}
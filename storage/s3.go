@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	UseSSL    bool
+	// PublicURL is the base URL objects are served from, e.g. a CDN.
+	// Defaults to the bucket URL on the endpoint.
+	PublicURL string
+}
+
+// S3 stores objects in an S3-compatible bucket, such as a local MinIO.
+type S3 struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+// NewS3 connects to the endpoint and creates the bucket if it does not exist.
+func NewS3(config S3Config) (*S3, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating S3 client: %s", err.Error())
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error checking bucket %s: %s", config.Bucket, err.Error())
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, fmt.Errorf("error creating bucket %s: %s", config.Bucket, err.Error())
+		}
+	}
+
+	publicURL := config.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if config.UseSSL {
+			scheme = "https"
+		}
+		publicURL = fmt.Sprintf("%s://%s/%s", scheme, config.Endpoint, config.Bucket)
+	}
+
+	return &S3{client: client, bucket: config.Bucket, publicURL: strings.TrimSuffix(publicURL, "/")}, nil
+}
+
+func (s3 *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s3.client.PutObject(ctx, s3.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s3 *S3) Delete(ctx context.Context, key string) error {
+	return s3.client.RemoveObject(ctx, s3.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s3 *S3) URL(key string) string {
+	return s3.publicURL + "/" + key
+}
@@ -0,0 +1,63 @@
+// Package storage keeps uploaded media files behind a pluggable backend.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Storage saves and removes objects addressed by a slash-separated key.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the public address of the object.
+	URL(key string) string
+}
+
+var Default Storage
+
+// Init configures Default from the environment.
+//
+// STORAGE_DRIVER selects the backend: "local" (default) or "s3".
+// The local backend writes to STORAGE_LOCAL_DIR and serves files under STORAGE_PUBLIC_URL.
+// The s3 backend uses S3_ENDPOINT, S3_ACCESS_KEY, S3_SECRET_KEY, S3_BUCKET and S3_USE_SSL,
+// and builds URLs from STORAGE_PUBLIC_URL, or from the endpoint and bucket if it is empty.
+func Init() error {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "media"
+		}
+		publicURL := os.Getenv("STORAGE_PUBLIC_URL")
+		if publicURL == "" {
+			publicURL = "/media"
+		}
+		local, err := NewLocal(dir, publicURL)
+		if err != nil {
+			return err
+		}
+		Default = local
+	case "s3":
+		useSSL, _ := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
+		s3, err := NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			UseSSL:    useSSL,
+			PublicURL: os.Getenv("STORAGE_PUBLIC_URL"),
+		})
+		if err != nil {
+			return err
+		}
+		Default = s3
+	default:
+		return fmt.Errorf("unknown storage driver %q", driver)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+
+	// Register decoders for the accepted upload formats
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxImagePixels caps width x height of images that are decoded. A small file can
+// declare huge dimensions and would otherwise exhaust memory when decoded.
+const MaxImagePixels = 40_000_000
+
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// Thumbnail scales the image down to fit in a size x size square and encodes it as JPEG.
+// Images that already fit are only re-encoded.
+func Thumbnail(src []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Over, nil)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, thumbnail, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
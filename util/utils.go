@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"unicode"
 
 	"github.com/BurntSushi/toml"
@@ -50,6 +51,23 @@ func IsValidPassword(password string) (bool, string) {
 	return false, message[:len(message)-1] + "."
 }
 
+// Slugify turns a name into a lowercase, URL-friendly identifier,
+// e.g. "Gaming Laptops" becomes "gaming-laptops".
+func Slugify(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			slug.WriteRune(r)
+			dash = false
+		} else if !dash && slug.Len() > 0 {
+			slug.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(slug.String(), "-")
+}
+
 func LoadConfig(path string) (*types.Config, error) {
 	var config types.Config
 	if _, err := toml.DecodeFile(path, &config); err != nil {